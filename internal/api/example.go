@@ -5,18 +5,22 @@ import (
 	"log/slog"
 
 	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+	"github.com/prezhdarov/prometheus-exporter/pkg/secret"
 )
 
 var (
 	// This is example of all things necessary for simple http (REST anyone?) API configuration. Get all these defined and let the feast begin.
-	//apiUser   = flag.String("api.username", "", "Username to login")
-	//apiPasswd = flag.String("api.password", "", "Password for the user above")
+	// The password is a secret flag - besides a plain value it takes file:/path, env:NAME or exec:command references, which keeps it out of process listings.
+	apiUser   = flag.String("api.username", "", "Username to login")
+	apiPasswd = secret.Flag("api.password", "Password for the user above. Accepts file:, env: and exec: references.")
 	apiServer = flag.String("api.server", "", "Server address in host:port format.")
 	//apiSchema = flag.String("api.schema", "https", "Use HTTP or HTTPS")
 	//apiSSL    = flag.Bool("api.ssl", false, "Trust SSL or trust")
 )
 
-// Nothing to see here..
+// Nothing to see here.. A real one would keep its http.Client here, and give it a transport dialing through exporter.TargetDialer
+// so -probe.targets.allow and -probe.targets.deny hold for the addresses it really connects to.
 type APIClient struct {
 	//logger  log.Logger
 }
@@ -31,7 +35,7 @@ func Load(logger *slog.Logger) {
 // This here puts it into the collector settings. Remember those handlers and handle functions? Yes, there!
 func init() {
 
-	collector.RegisterAPI(NewAPI())
+	collector.RegisterNamedAPI("example", NewAPI())
 
 }
 
@@ -47,9 +51,13 @@ func (vm *APIClient) Login(target string, logger *slog.Logger) (map[string]any,
 
 	loginData := make(map[string]any, 0)
 
+	// Flags may change on reload. Read them holding the configuration, but let go before talking to the API - a reload
+	// waiting on a slow login would hold up every request.
 	if target == "" {
 
+		release := config.Hold()
 		target = *apiServer
+		release()
 
 	}
 
@@ -60,6 +68,43 @@ func (vm *APIClient) Login(target string, logger *slog.Logger) (map[string]any,
 	return loginData, nil
 }
 
+// LoginWithConfig is called instead of Login when /probe uses a module. The config holds whatever the module defined under api:
+// scheme, credentials, TLS settings and timeouts. Use what makes sense for your API and ignore the rest.
+func (vm *APIClient) LoginWithConfig(target string, config collector.LoginConfig, logger *slog.Logger) (map[string]any, error) {
+
+	// Modules without credentials fall back to the flags.
+	if config.Credentials.Username == "" {
+		config.Credentials.Username, config.Credentials.Password = flagCredentials()
+	}
+
+	// Value() resolves file:, env: and exec: references. Rotated secrets are picked up on their own.
+	if _, err := config.Credentials.Password.Value(); err != nil {
+		return nil, err
+	}
+
+	loginData, err := vm.Login(target, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Credentials come from the module or the selected auth module. Password and Token log as <secret>, call Value() to get them.
+	logger.Debug("logged in with module settings", "module", config.Module, "auth_module", config.AuthModule, "scheme", config.Scheme,
+		"username", config.Credentials.Username, "password", config.Credentials.Password, "timeout", config.Timeout)
+
+	loginData["scheme"] = config.Scheme
+
+	return loginData, nil
+}
+
+// flagCredentials reads the credential flags, holding the configuration just as long.
+func flagCredentials() (string, secret.Secret) {
+
+	release := config.Hold()
+	defer release()
+
+	return *apiUser, *apiPasswd
+}
+
 // The Logout - just pass the map created in Login... Your logout should know what to do with it (if anything at all)
 func (vm *APIClient) Logout(loginData map[string]any, logger *slog.Logger) error {
 
@@ -1,8 +1,6 @@
 package exampleCollectors
 
 import (
-	"flag"
-	"fmt"
 	"log/slog"
 	"time"
 
@@ -15,8 +13,6 @@ const (
 	testSubsystem = "test"
 )
 
-var testCollectorFlag = flag.Bool("collector.test", collector.DefaultEnabled, fmt.Sprintf("Enable the %s collector (default: %v)", testSubsystem, collector.DefaultEnabled))
-
 // The collector itself
 type testCollector struct {
 	logger *slog.Logger
@@ -27,40 +23,51 @@ func Load(logger *slog.Logger) {
 	logger.Info("loading example collector set")
 }
 
-// This adds the collector to the set of collectors to be used during Collect phase. The testCollectorFlag is used to either set the collector as enabled or disabled (bool)
+// This adds the collector to the set of collectors to be used during Collect phase. Register creates the -collector.test flag to enable or disable it,
+// the description and tags show up in -help and on the landing page, and the tags double as -collectors.profile names.
 func init() {
-	collector.RegisterCollector("test", testCollectorFlag, NewTestCollector)
+	collector.Register(collector.Registration{
+		Name:          testSubsystem,
+		Description:   "fake metrics, one with a timestamp",
+		Default:       collector.DefaultEnabled,
+		Tags:          []string{"minimal", "example"},
+		API:           "example",
+		ScrapeFactory: NewTestCollector,
+	})
 }
 
 // The Baron survives another drowning... just..
-func NewTestCollector(logger *slog.Logger) (collector.Collector, error) {
+func NewTestCollector(logger *slog.Logger) (collector.ScrapeCollector, error) {
 	return &testCollector{logger}, nil
 }
 
-// This is where the magic happens. Here clientAPI.Get can be consumed and metrics created with the result and pushed out.
-func (c *testCollector) Update(ch chan<- prometheus.Metric, namespace string, clientAPI collector.ClientAPI, loginData map[string]any, params map[string]string) error {
+// This is where the magic happens. Here the API can be consumed and metrics created with the result and pushed out. sc has it all:
+// target, module, parameters, the session Login returned. Calling the API through sc.Get gets each call traced and counted.
+func (c *testCollector) Scrape(sc *collector.ScrapeContext) error {
 
 	extraConfig := make(map[string]any, 0)
 
-	clientAPI.Get(loginData, extraConfig, c.logger)
+	if _, err := sc.Get(extraConfig); err != nil {
+		return err
+	}
 
 	// This is a simple metric of type Gauge (could be Counter for all it matters too).
-	ch <- prometheus.MustNewConstMetric(
+	sc.Send(prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, testSubsystem, "some_fake_metric"),
+			prometheus.BuildFQName(sc.Namespace, testSubsystem, "some_fake_metric"),
 			"This is a fake metric... but is it?", nil, nil,
 		), prometheus.GaugeValue, 1.0,
-	)
+	))
 
 	// This is a simple metric, but with a timestamp.
-	ch <- prometheus.NewMetricWithTimestamp(
+	sc.Send(prometheus.NewMetricWithTimestamp(
 		time.Now(), prometheus.MustNewConstMetric(
 			prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, testSubsystem, "some_fake_metric_with_time"),
+				prometheus.BuildFQName(sc.Namespace, testSubsystem, "some_fake_metric_with_time"),
 				"This is also a fake metric... with a timestamp!", nil, nil,
 			), prometheus.GaugeValue, 1.0,
 		),
-	)
+	))
 
 	return nil
 }
@@ -1,21 +1,38 @@
 package collector
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prezhdarov/prometheus-exporter/pkg/logging"
+	"github.com/prezhdarov/prometheus-exporter/pkg/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 func (cs *CollectorSet) Collect(ch chan<- prometheus.Metric) {
+	cs.CollectErr(ch)
+}
+
+// CollectErr is Collect telling whether the collection failed: the login error, or which collectors failed or were skipped.
+// Results that failed are best not kept for later.
+func (cs *CollectorSet) CollectErr(ch chan<- prometheus.Metric) error {
+
+	ctx, span := tracing.Start(cs.context(), "collect", tracing.KindInternal, tracing.String("target", cs.target))
+	defer span.End()
 
 	begin := time.Now()
 
-	clientData, err := cs.clientAPI.Login(cs.target, cs.logger)
+	clientData, err := cs.login(ctx)
 	if err != nil {
 
 		cs.logger.Error("Login failed", "target", clientData["target"], "err", err)
-		return
+		span.SetError(err)
+		return fmt.Errorf("login: %w", err)
 
 	} else {
 
@@ -29,24 +46,97 @@ func (cs *CollectorSet) Collect(ch chan<- prometheus.Metric) {
 
 	cs.logger.Debug("number of collectors to scrape", "count", len(cs.Collectors))
 
+	// Collectors run as a DAG: each one starts as soon as the collectors it depends on are done, and is skipped (and counts as
+	// failed itself) if one of them failed or is not part of this set. Results travel between them through the store.
+	store := newStore()
+
+	done := make(map[string]chan struct{}, len(cs.Collectors))
+	for name := range cs.Collectors {
+		done[name] = make(chan struct{})
+	}
+
+	failedMtx := sync.Mutex{}
+	failed := make(map[string]bool)
+
+	dependencyFailed := func(name string) string {
+		for _, dep := range cs.dependsOn[name] {
+			depDone, ok := done[dep]
+			if !ok {
+				return dep
+			}
+			<-depDone
+			failedMtx.Lock()
+			depFailed := failed[dep]
+			failedMtx.Unlock()
+			if depFailed {
+				return dep
+			}
+		}
+		return ""
+	}
+
 	wg.Add(len(cs.Collectors))
 	for name, c := range cs.Collectors {
 		go func(name string, c Collector) {
 			defer wg.Done()
+			defer close(done[name])
+
+			logger := logging.ForCollector(cs.logger, name)
+
+			if dep := dependencyFailed(name); dep != "" {
+				logger.Warn("collector skipped, a dependency failed or is not enabled", "name", name, "dependency", dep)
+				failedMtx.Lock()
+				failed[name] = true
+				failedMtx.Unlock()
+				ch <- prometheus.MustNewConstMetric(cs.ScrapeMetrics.Success, prometheus.GaugeValue, 0, name)
+				return
+			}
+
+			uctx, span := tracing.Start(ctx, "Update", tracing.KindInternal, tracing.String("collector", name))
+
+			sc := &ScrapeContext{
+				Context:   uctx,
+				Namespace: cs.namespace,
+				Target:    cs.target,
+				Module:    cs.module,
+				Params:    cs.params,
+				API:       cs.clientAPI,
+				Session:   clientData,
+				Logger:    logger.With("collector", name),
+				Store:     store,
+				Metrics:   ChannelSink(ch),
+			}
+
+			// On sampled scrapes sc.Get times and counts the API calls. The API itself is handed over as it is, collectors
+			// may well type-assert it, so the calls of Update collectors, made on it directly, are neither traced nor counted:
+			// they get no get_calls attribute rather than a wrong one.
+			if _, ok := c.(ScrapeCollector); ok && span.IsRecording() {
+				sc.collector = name
+				sc.gets = &atomic.Int64{}
+			}
 
 			begin := time.Now()
 
-			err := c.Update(ch, cs.namespace, cs.clientAPI, clientData, cs.extraParams)
+			err := UpdateAdapter(c).Scrape(sc)
 
 			duration := time.Since(begin)
 
+			if sc.gets != nil {
+				span.SetAttributes(tracing.Int("get_calls", int(sc.gets.Load())))
+			}
+			span.SetError(err)
+			span.End()
+
 			var success float64
 
 			if err != nil {
-				cs.logger.Error("collector failed", "name", name, "duration_seconds", duration.Seconds(), "err", err)
+				failedMtx.Lock()
+				failed[name] = true
+				failedMtx.Unlock()
+				logger.Error("collector failed", "name", name, "duration_seconds", duration.Seconds(), "err", err)
 				success = 0
 			} else {
-				cs.logger.Debug("collector scraped successfully", "target", clientData["target"].(string), "name", name, "duration_seconds", duration.Seconds())
+				logger.Debug("collector scraped successfully", "target", clientData["target"].(string), "name", name, "duration_seconds", duration.Seconds())
 				success = 1
 			}
 			ch <- prometheus.MustNewConstMetric(cs.ScrapeMetrics.Duration, prometheus.GaugeValue, duration.Seconds(), name)
@@ -58,9 +148,12 @@ func (cs *CollectorSet) Collect(ch chan<- prometheus.Metric) {
 
 	lobegin := time.Now()
 
+	_, lospan := tracing.Start(ctx, "Logout", tracing.KindClient, tracing.String("target", cs.target))
+
 	if err := cs.clientAPI.Logout(clientData, cs.logger); err != nil {
 
 		cs.logger.Error("Logout failed", "target", clientData["target"], "err", err)
+		lospan.SetError(err)
 
 	} else {
 
@@ -68,7 +161,41 @@ func (cs *CollectorSet) Collect(ch chan<- prometheus.Metric) {
 
 	}
 
+	lospan.End()
+
 	ch <- prometheus.MustNewConstMetric(cs.ScrapeMetrics.Duration, prometheus.GaugeValue, time.Since(lobegin).Seconds(), "logout") //Same as Login above
 
 	ch <- prometheus.MustNewConstMetric(cs.ScrapeMetrics.Duration, prometheus.GaugeValue, time.Since(begin).Seconds(), "all_collectors")
+
+	if len(failed) > 0 {
+		names := make([]string, 0, len(failed))
+		for name := range failed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("collectors failed: %s", strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+// login hands the module settings to the API if it can take them, otherwise it logs in with the target only.
+func (cs *CollectorSet) login(ctx context.Context) (clientData map[string]any, err error) {
+
+	_, span := tracing.Start(ctx, "Login", tracing.KindClient, tracing.String("target", cs.target))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	if cs.module != nil {
+		span.SetAttributes(tracing.String("module", cs.module.Name))
+		if api, ok := cs.clientAPI.(ModuleClientAPI); ok {
+			config := cs.module.Login
+			config.Module = cs.module.Name
+			return api.LoginWithConfig(cs.target, config, cs.logger)
+		}
+	}
+
+	return cs.clientAPI.Login(cs.target, cs.logger)
 }
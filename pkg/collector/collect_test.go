@@ -0,0 +1,182 @@
+package collector
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+type fakeAPI struct{}
+
+func (fakeAPI) Login(target string, logger *slog.Logger) (map[string]any, error) {
+	return map[string]any{"target": target}, nil
+}
+
+func (fakeAPI) Logout(map[string]any, *slog.Logger) error { return nil }
+
+func (fakeAPI) Get(map[string]any, map[string]any, *slog.Logger) (any, error) { return nil, nil }
+
+type scrapeFunc func(sc *ScrapeContext) error
+
+func (f scrapeFunc) Scrape(sc *ScrapeContext) error { return f(sc) }
+
+func TestFindCycle(t *testing.T) {
+
+	tests := []struct {
+		name string
+		deps map[string][]string
+		want []string
+	}{
+		{"none", map[string][]string{"a": {"b"}, "b": {"c"}, "c": nil}, nil},
+		{"diamond", map[string][]string{"a": {"b", "c"}, "b": {"d"}, "c": {"d"}, "d": nil}, nil},
+		{"outside dependency", map[string][]string{"a": {"x"}}, nil},
+		{"self", map[string][]string{"a": {"a"}}, []string{"a", "a"}},
+		{"loop", map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}}, []string{"a", "b", "c", "a"}},
+		{"loop further down", map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"b"}}, []string{"b", "c", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got := findCycle(tt.deps)
+
+			if tt.want == nil {
+				if got != nil {
+					t.Fatalf("findCycle() = %v, want none", got)
+				}
+				return
+			}
+
+			// Where the search enters a cycle depends on map order, so compare it as a rotation.
+			if len(got) != len(tt.want) || got[0] != got[len(got)-1] {
+				t.Fatalf("findCycle() = %v, want %v", got, tt.want)
+			}
+			ring := got[:len(got)-1]
+			i := slices.Index(ring, tt.want[0])
+			if i < 0 || !slices.Equal(append(slices.Clone(ring[i:]), ring[:i]...), tt.want[:len(tt.want)-1]) {
+				t.Fatalf("findCycle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+var testVMs = NewKey[[]string]("test.vms")
+
+func TestCollectDependencies(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		disable []string
+		module  []string
+		wantRan []string
+	}{
+		// inventory publishes, vms reads it, broken fails and its dependent is skipped.
+		{"everything", nil, nil, []string{"inventory", "vms", "broken"}},
+		// Dependencies come along with the collectors of a module.
+		{"module", nil, []string{"vms"}, []string{"inventory", "vms"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var mtx sync.Mutex
+			var ran []string
+			run := func(name string, f func(sc *ScrapeContext) error) func(*slog.Logger) (ScrapeCollector, error) {
+				return func(*slog.Logger) (ScrapeCollector, error) {
+					return scrapeFunc(func(sc *ScrapeContext) error {
+						mtx.Lock()
+						ran = append(ran, name)
+						mtx.Unlock()
+						return f(sc)
+					}), nil
+				}
+			}
+
+			var got []string
+
+			r := NewRegistry()
+			r.RegisterAPI(fakeAPI{})
+			r.Register(Registration{Name: "inventory", ScrapeFactory: run("inventory", func(sc *ScrapeContext) error {
+				Publish(sc.Store, testVMs, []string{"vm1", "vm2"})
+				return nil
+			})})
+			r.Register(Registration{Name: "vms", Default: true, DependsOn: []string{"inventory"}, ScrapeFactory: run("vms", func(sc *ScrapeContext) error {
+				got, _ = Lookup(sc.Store, testVMs)
+				return nil
+			})})
+			r.Register(Registration{Name: "broken", Default: true, ScrapeFactory: run("broken", func(*ScrapeContext) error {
+				return errors.New("broken")
+			})})
+			r.Register(Registration{Name: "needs_broken", Default: true, DependsOn: []string{"broken"}, ScrapeFactory: run("needs_broken", func(*ScrapeContext) error {
+				return nil
+			})})
+
+			if err := r.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			var module *Module
+			if tt.module != nil {
+				module = &Module{Name: "m", Collectors: tt.module}
+			}
+
+			cs, err := r.NewProbeCollectorSet("test", "target", module, nil, discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ch := make(chan prometheus.Metric)
+			go func() {
+				cs.Collect(ch)
+				close(ch)
+			}()
+			for range ch {
+			}
+
+			slices.Sort(ran)
+			slices.Sort(tt.wantRan)
+			if !slices.Equal(ran, tt.wantRan) {
+				t.Fatalf("ran %v, want %v", ran, tt.wantRan)
+			}
+
+			if !slices.Equal(got, []string{"vm1", "vm2"}) {
+				t.Fatalf("vms saw %v in the store", got)
+			}
+		})
+	}
+}
+
+func TestValidateDisabledDependency(t *testing.T) {
+
+	r := newCommandLineRegistry(map[string]bool{"collector.inventory": true})
+
+	off := false
+	r.Register(Registration{Name: "inventory", Flag: &off, Factory: nopFactory})
+	r.Register(Registration{Name: "vms", Default: true, DependsOn: []string{"inventory"}, Factory: nopFactory})
+
+	if err := r.Validate(); err == nil {
+		t.Fatal("Validate() accepted a collector whose dependency is explicitly disabled")
+	}
+
+	// Without the explicit flag the dependency is enabled along with vms.
+	r.flagPassed = func(string) bool { return false }
+
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	r.mtx.Lock()
+	enabled, _ := r.enabledCollectors()
+	r.mtx.Unlock()
+
+	if !enabled["inventory"] {
+		t.Fatal("inventory was not enabled along with vms")
+	}
+}
@@ -1,10 +1,12 @@
 package collector
 
 import (
+	"context"
 	"flag"
 	"log/slog"
-	"sync"
+	"time"
 
+	"github.com/prezhdarov/prometheus-exporter/pkg/secret"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -14,22 +16,65 @@ type ClientAPI interface {
 	Get(loginData, extraConfig map[string]any, logger *slog.Logger) (any, error)
 }
 
+// LoginConfig carries the per-module settings a ClientAPI needs to reach a target. Which of them make sense is up to the API.
+type LoginConfig struct {
+	Module      string            `yaml:"-"`
+	AuthModule  string            `yaml:"-"`
+	Scheme      string            `yaml:"scheme"`
+	Credentials Credentials       `yaml:",inline"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	TLS         TLSConfig         `yaml:"tls_config"`
+	Options     map[string]string `yaml:"options"`
+}
+
+// Credentials are what an API authenticates with. Password and Token never show up in logs.
+type Credentials struct {
+	Username string        `yaml:"username"`
+	Password secret.Secret `yaml:"password"`
+	Token    secret.Secret `yaml:"token"`
+}
+
+type TLSConfig struct {
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+}
+
+// ModuleClientAPI is a ClientAPI that can take per-module settings. APIs implementing only ClientAPI keep getting Login with the target alone.
+type ModuleClientAPI interface {
+	ClientAPI
+	LoginWithConfig(target string, config LoginConfig, logger *slog.Logger) (map[string]any, error)
+}
+
+// Module narrows a CollectorSet down to a set of collectors and the settings to log in with. An empty Collectors list means all enabled ones.
+type Module struct {
+	Name       string
+	Collectors []string
+	Login      LoginConfig
+}
+
 type ScrapeMetrics struct {
 	Success  *prometheus.Desc
 	Duration *prometheus.Desc
 }
 
-// Collector is the interface a collector has to implement.
+// Collector is the interface a collector has to implement. New collectors can implement ScrapeCollector instead and get
+// everything in one ScrapeContext; Collect runs both kinds.
 type Collector interface {
 	Update(ch chan<- prometheus.Metric, namespace string, clientAPI ClientAPI, clientData map[string]any, extraParams map[string]string) error
 }
 
 type CollectorSet struct {
 	Collectors    map[string]Collector
+	dependsOn     map[string][]string
+	ctx           context.Context
 	clientAPI     ClientAPI
 	target        string
+	module        *Module
 	namespace     string
-	extraParams   map[string]string
+	params        Params
 	logger        *slog.Logger
 	ScrapeMetrics ScrapeMetrics
 }
@@ -39,107 +84,11 @@ const (
 	DefaultDisabled = false
 )
 
-var disableDefaultCollector = flag.Bool("disable.default.collectors", DefaultDisabled, "If set only explicitly enabled collectors will be enabled")
-
 var (
-	registeredClientAPI    ClientAPI
-	factories              = make(map[string]func(logger *slog.Logger) (Collector, error))
-	collectorState         = make(map[string]*bool)
-	initiatedCollectorsMtx = sync.Mutex{}
-	initiatedCollectors    = make(map[string]Collector)
+	disableDefaultCollector = flag.Bool("disable.default.collectors", DefaultDisabled, "If set only explicitly enabled collectors will be enabled")
+	collectorsProfile       = flag.String("collectors.profile", "", "Comma separated profiles of collectors to enable instead of their defaults: a profile registered by the exporter, a collector tag, \"default\" or \"full\" for all of them. collector.<name> flags still win.")
 )
 
-func isFlagPassed(name string) bool {
-	found := false
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == "collector."+name {
-			found = true
-		}
-	})
-	return found
-}
-
-func disableDefaultCollectors() {
-	for collector := range collectorState {
-		if !isFlagPassed(collector) {
-			*collectorState[collector] = false
-		}
-	}
-}
-
-func RegisterAPI(clientAPI ClientAPI) {
-	registeredClientAPI = clientAPI
-}
-
-func RegisterCollector(collector string, flag *bool, factory func(logger *slog.Logger) (Collector, error)) {
-
-	collectorState[collector] = flag
-	factories[collector] = factory
-}
-
-func NewCollectorSet(namespace, target string, params map[string]string, logger *slog.Logger) (CollectorSet, error) {
-
-	var sm ScrapeMetrics
-
-	sm.Duration = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
-		"Duration of a collector scrape.",
-		[]string{"collector"},
-		nil,
-	)
-
-	sm.Success = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "scrape", "collector_success"),
-		"Whether a collector succeeded.",
-		[]string{"collector"},
-		nil,
-	)
-
-	collectors := make(map[string]Collector)
-
-	initiatedCollectorsMtx.Lock()
-	defer initiatedCollectorsMtx.Unlock()
-
-	if *disableDefaultCollector {
-		disableDefaultCollectors()
-	}
-
-	for key, enabled := range collectorState {
-
-		if !*enabled {
-			logger.Debug("collector disabled", "name", key)
-			continue
-		}
-
-		logger.Debug("collector enabled", "name", key)
-
-		if collector, ok := initiatedCollectors[key]; ok {
-			collectors[key] = collector
-		} else {
-			collector, err := factories[key](logger.With("collector", key))
-			if err != nil {
-				return CollectorSet{}, err
-			}
-
-			collectors[key] = collector
-
-			initiatedCollectors[key] = collector
-
-		}
-
-	}
-
-	return CollectorSet{
-		Collectors:    collectors,
-		clientAPI:     registeredClientAPI,
-		target:        target,
-		namespace:     namespace,
-		extraParams:   params,
-		logger:        logger,
-		ScrapeMetrics: sm,
-	}, nil
-}
-
 func (cs *CollectorSet) Describe(ch chan<- *prometheus.Desc) {
 	ch <- cs.ScrapeMetrics.Duration
 	ch <- cs.ScrapeMetrics.Success
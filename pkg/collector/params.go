@@ -0,0 +1,226 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParamType is what a probe parameter's values must parse as.
+type ParamType string
+
+const (
+	ParamString   ParamType = "string"
+	ParamInt      ParamType = "int"
+	ParamFloat    ParamType = "float"
+	ParamBool     ParamType = "bool"
+	ParamDuration ParamType = "duration"
+)
+
+// ParamSpec declares a probe parameter.
+type ParamSpec struct {
+	Name        string
+	Description string
+	// Type defaults to ParamString.
+	Type ParamType
+	// Default is used when neither the request nor the module gives the parameter. Multi parameters take a comma separated list.
+	Default  string
+	Required bool
+	// Allowed lists the only values accepted, if not empty.
+	Allowed []string
+	// Pattern is a regular expression every value has to match as a whole, if not empty.
+	Pattern string
+	// Multi parameters take several values, repeated (gw=a&gw=b) or comma separated (gw=a,b).
+	Multi bool
+}
+
+// ParamParser checks probe requests against a list of ParamSpecs.
+type ParamParser struct {
+	specs    []ParamSpec
+	byName   map[string]bool
+	patterns map[string]*regexp.Regexp
+}
+
+// NewParamParser validates specs: names unique, types known, patterns compiling and defaults passing their own checks.
+func NewParamParser(specs []ParamSpec) (*ParamParser, error) {
+
+	p := &ParamParser{byName: make(map[string]bool), patterns: make(map[string]*regexp.Regexp)}
+
+	var errs []error
+
+	for _, spec := range specs {
+
+		if spec.Type == "" {
+			spec.Type = ParamString
+		}
+
+		switch {
+		case spec.Name == "":
+			errs = append(errs, errors.New("probe parameter without a name"))
+			continue
+		case p.byName[spec.Name]:
+			errs = append(errs, fmt.Errorf("probe parameter %s declared twice", spec.Name))
+			continue
+		}
+		p.byName[spec.Name] = true
+
+		switch spec.Type {
+		case ParamString, ParamInt, ParamFloat, ParamBool, ParamDuration:
+		default:
+			errs = append(errs, fmt.Errorf("probe parameter %s: unknown type %q", spec.Name, spec.Type))
+		}
+
+		if spec.Pattern != "" {
+			re, err := regexp.Compile("^(?:" + spec.Pattern + ")$")
+			if err != nil {
+				errs = append(errs, fmt.Errorf("probe parameter %s: %w", spec.Name, err))
+			} else {
+				p.patterns[spec.Name] = re
+			}
+		}
+
+		p.specs = append(p.specs, spec)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	for _, spec := range p.specs {
+		if spec.Default != "" {
+			for _, problem := range p.check(spec, p.split(spec, []string{spec.Default})) {
+				errs = append(errs, fmt.Errorf("default of %s", problem))
+			}
+		}
+	}
+
+	return p, errors.Join(errs...)
+}
+
+// Specs returns the declared parameters.
+func (p *ParamParser) Specs() []ParamSpec {
+	return p.specs
+}
+
+// Parse picks the declared parameters out of query, falling back to defaults (a module's parameters, say) and then to each
+// spec's Default. It returns every problem of the request, not just the first. Only request values are checked: defaults come
+// from the configuration, which CheckDefaults vets as it is loaded, so a bad one is no fault of the client. Request parameters
+// not declared are left out, defaults not declared are passed on as they are.
+func (p *ParamParser) Parse(query url.Values, defaults map[string]string) (Params, []string) {
+
+	params := make(Params)
+	problems := []string{}
+
+	for _, spec := range p.specs {
+
+		values := p.split(spec, query[spec.Name])
+
+		if !spec.Multi && len(values) > 1 {
+			problems = append(problems, fmt.Sprintf("parameter %s: given %d times, takes a single value", spec.Name, len(values)))
+			continue
+		}
+
+		if len(values) > 0 {
+			if valueProblems := p.check(spec, values); len(valueProblems) > 0 {
+				problems = append(problems, valueProblems...)
+				continue
+			}
+		}
+
+		if len(values) == 0 && defaults[spec.Name] != "" {
+			values = p.split(spec, []string{defaults[spec.Name]})
+		}
+		if len(values) == 0 && spec.Default != "" {
+			values = p.split(spec, []string{spec.Default})
+		}
+
+		if len(values) == 0 {
+			if spec.Required {
+				problems = append(problems, fmt.Sprintf("parameter %s is required", spec.Name))
+			}
+			continue
+		}
+
+		params[spec.Name] = values
+	}
+
+	for name, value := range defaults {
+		if _, declared := p.byName[name]; !declared && value != "" {
+			params[name] = []string{value}
+		}
+	}
+
+	return params, problems
+}
+
+// CheckDefaults checks defaults, the params of a module, against the declared parameters, for when the module is loaded.
+// Defaults not declared are not checked.
+func (p *ParamParser) CheckDefaults(defaults map[string]string) []string {
+
+	problems := []string{}
+
+	for _, spec := range p.specs {
+		if value := defaults[spec.Name]; value != "" {
+			problems = append(problems, p.check(spec, p.split(spec, []string{value}))...)
+		}
+	}
+
+	return problems
+}
+
+// split drops empty values and, for Multi parameters, splits comma separated ones.
+func (p *ParamParser) split(spec ParamSpec, raw []string) []string {
+
+	values := []string{}
+
+	for _, value := range raw {
+		parts := []string{value}
+		if spec.Multi {
+			parts = strings.Split(value, ",")
+		}
+		for _, part := range parts {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+
+	return values
+}
+
+func (p *ParamParser) check(spec ParamSpec, values []string) []string {
+
+	problems := []string{}
+
+	for _, value := range values {
+
+		var err error
+
+		switch spec.Type {
+		case ParamInt:
+			_, err = strconv.Atoi(value)
+		case ParamFloat:
+			_, err = strconv.ParseFloat(value, 64)
+		case ParamBool:
+			_, err = strconv.ParseBool(value)
+		case ParamDuration:
+			_, err = time.ParseDuration(value)
+		}
+
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("parameter %s: %q is not a valid %s", spec.Name, value, spec.Type))
+		case len(spec.Allowed) > 0 && !slices.Contains(spec.Allowed, value):
+			problems = append(problems, fmt.Sprintf("parameter %s: %q is not one of %s", spec.Name, value, strings.Join(spec.Allowed, ", ")))
+		case p.patterns[spec.Name] != nil && !p.patterns[spec.Name].MatchString(value):
+			problems = append(problems, fmt.Sprintf("parameter %s: %q does not match %s", spec.Name, value, spec.Pattern))
+		}
+	}
+
+	return problems
+}
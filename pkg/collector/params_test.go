@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParamParserParse(t *testing.T) {
+
+	parser, err := NewParamParser([]ParamSpec{
+		{Name: "port", Type: ParamInt, Default: "443"},
+		{Name: "mode", Allowed: []string{"fast", "full"}},
+		{Name: "gateways", Multi: true, Pattern: `[a-z0-9]+`},
+		{Name: "timeout", Type: ParamDuration, Required: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		defaults map[string]string
+		want     Params
+		problems []string
+	}{
+		{
+			name:  "spec defaults",
+			query: "timeout=5s",
+			want:  Params{"port": {"443"}, "timeout": {"5s"}},
+		},
+		{
+			name:     "module defaults win over spec defaults",
+			query:    "timeout=5s",
+			defaults: map[string]string{"port": "8443", "mode": "fast"},
+			want:     Params{"port": {"8443"}, "mode": {"fast"}, "timeout": {"5s"}},
+		},
+		{
+			name:     "request wins over module defaults",
+			query:    "timeout=5s&port=9443",
+			defaults: map[string]string{"port": "8443"},
+			want:     Params{"port": {"9443"}, "timeout": {"5s"}},
+		},
+		{
+			name:  "multi repeated and comma separated",
+			query: "timeout=5s&gateways=a,b&gateways=c",
+			want:  Params{"port": {"443"}, "gateways": {"a", "b", "c"}, "timeout": {"5s"}},
+		},
+		{
+			name:     "undeclared module defaults pass through",
+			query:    "timeout=5s&other=x",
+			defaults: map[string]string{"extra": "y"},
+			want:     Params{"port": {"443"}, "timeout": {"5s"}, "extra": {"y"}},
+		},
+		{
+			name:     "bad module defaults are no fault of the request",
+			query:    "timeout=5s",
+			defaults: map[string]string{"port": "https"},
+			want:     Params{"port": {"https"}, "timeout": {"5s"}},
+		},
+		{
+			name:     "required from module defaults",
+			defaults: map[string]string{"timeout": "1m"},
+			want:     Params{"port": {"443"}, "timeout": {"1m"}},
+		},
+		{
+			name:     "every request problem",
+			query:    "port=https&mode=slow&gateways=A&port=1",
+			problems: []string{"parameter port: given 2 times", `parameter mode: "slow" is not one of`, `parameter gateways: "A" does not match`, "parameter timeout is required"},
+		},
+		{
+			name:     "wrong type",
+			query:    "timeout=soon",
+			problems: []string{`parameter timeout: "soon" is not a valid duration`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, problems := parser.Parse(query, tt.defaults)
+
+			if len(problems) != len(tt.problems) {
+				t.Fatalf("Parse() problems = %q, want %q", problems, tt.problems)
+			}
+			for i, want := range tt.problems {
+				if !strings.HasPrefix(problems[i], want) {
+					t.Fatalf("Parse() problems = %q, want %q", problems, tt.problems)
+				}
+			}
+			if tt.problems != nil {
+				return
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("Parse() = %v, want %v", got, tt.want)
+			}
+			for name, values := range tt.want {
+				if !slices.Equal(got[name], values) {
+					t.Fatalf("Parse() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParamParserCheckDefaults(t *testing.T) {
+
+	parser, err := NewParamParser([]ParamSpec{
+		{Name: "port", Type: ParamInt},
+		{Name: "gateways", Multi: true, Pattern: `[a-z0-9]+`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		defaults map[string]string
+		problems int
+	}{
+		{nil, 0},
+		{map[string]string{"port": "443", "gateways": "a,b", "other": "anything"}, 0},
+		{map[string]string{"port": "https"}, 1},
+		{map[string]string{"port": "https", "gateways": "a,B,C"}, 3},
+	}
+
+	for _, tt := range tests {
+		if problems := parser.CheckDefaults(tt.defaults); len(problems) != tt.problems {
+			t.Errorf("CheckDefaults(%v) = %q, want %d problems", tt.defaults, problems, tt.problems)
+		}
+	}
+}
+
+func TestNewParamParserErrors(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		specs []ParamSpec
+	}{
+		{"no name", []ParamSpec{{Type: ParamInt}}},
+		{"twice", []ParamSpec{{Name: "a"}, {Name: "a"}}},
+		{"unknown type", []ParamSpec{{Name: "a", Type: "uint"}}},
+		{"bad pattern", []ParamSpec{{Name: "a", Pattern: "("}}},
+		{"bad default", []ParamSpec{{Name: "a", Type: ParamBool, Default: "maybe"}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewParamParser(tt.specs); err == nil {
+			t.Errorf("%s: NewParamParser() accepted %v", tt.name, tt.specs)
+		}
+	}
+}
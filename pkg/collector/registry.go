@@ -0,0 +1,742 @@
+package collector
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Factory creates a collector. It is called once per registry, or once per set for debug sets.
+type Factory func(logger *slog.Logger) (Collector, error)
+
+// Registry holds an API, the collector factories with their enabled state and the collectors created so far. Registries are
+// independent of each other, so one process can run several collector sets, and tests can start from an empty one.
+type Registry struct {
+	mtx           sync.Mutex
+	clientAPI     ClientAPI
+	apiName       string
+	registrations map[string]Registration
+	factories     map[string]Factory
+	state         map[string]*bool
+	profiles      map[string][]string
+	initiated     map[string]Collector
+	factoryErrors map[string]error
+
+	// Only the default registry follows -disable.default.collectors and the collector.<name> command line flags.
+	commandLine bool
+	flagPassed  func(name string) bool
+}
+
+// NewRegistry returns an empty registry. Its collectors are enabled or disabled by the *bool they are registered with alone.
+func NewRegistry() *Registry {
+	return &Registry{
+		registrations: make(map[string]Registration),
+		factories:     make(map[string]Factory),
+		state:         make(map[string]*bool),
+		profiles:      make(map[string][]string),
+		initiated:     make(map[string]Collector),
+		factoryErrors: make(map[string]error),
+	}
+}
+
+// DefaultRegistry is what RegisterAPI, RegisterCollector and the other package level functions use.
+var DefaultRegistry = func() *Registry {
+	r := NewRegistry()
+	r.commandLine = true
+	r.flagPassed = isFlagPassed
+	return r
+}()
+
+func isFlagPassed(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// SetFlagPassed tells the registry how to find out whether a flag was given explicitly, on the command line, in the
+// configuration file or in the environment. flag.Visit can't tell a flag a reload set back to its default from one given
+// explicitly, so the config package hands over what it knows about the source of each value.
+func (r *Registry) SetFlagPassed(passed func(name string) bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.flagPassed = passed
+}
+
+// Built-in profiles: the registered defaults and every collector.
+const (
+	ProfileDefault = "default"
+	ProfileFull    = "full"
+)
+
+// enabledCollectors works out which collectors are enabled. Collectors given explicitly on the command line (or in the
+// configuration file) follow their flag, the others -collectors.profile or -disable.default.collectors, and failing that
+// their flag too. Dependencies of enabled collectors are enabled with them unless explicitly disabled. Only the default
+// registry looks at the command line; the flags themselves are never changed, so the outcome follows every reload. r.mtx
+// must be held.
+func (r *Registry) enabledCollectors() (map[string]bool, error) {
+
+	enabled := make(map[string]bool, len(r.state))
+	for name, state := range r.state {
+		enabled[name] = *state
+	}
+
+	if !r.commandLine {
+		r.enableDependencies(enabled)
+		return enabled, nil
+	}
+
+	if *collectorsProfile != "" {
+
+		profile, err := r.profileCollectors(strings.Split(*collectorsProfile, ","))
+		if err != nil {
+			return nil, err
+		}
+
+		for name := range enabled {
+			if !r.explicit(name) {
+				enabled[name] = profile[name]
+			}
+		}
+	} else if *disableDefaultCollector {
+		for name := range enabled {
+			if !r.explicit(name) {
+				enabled[name] = false
+			}
+		}
+	}
+
+	r.enableDependencies(enabled)
+
+	return enabled, nil
+}
+
+// enableDependencies enables what the enabled collectors depend on, all the way down, as a collector is no use without
+// it. Dependencies explicitly disabled stay off; Validate reports them. r.mtx must be held.
+func (r *Registry) enableDependencies(enabled map[string]bool) {
+
+	var enable func(name string)
+	enable = func(name string) {
+		for _, dep := range r.registrations[name].DependsOn {
+			if _, ok := r.factories[dep]; !ok || enabled[dep] || (r.commandLine && r.explicit(dep)) {
+				continue
+			}
+			enabled[dep] = true
+			enable(dep)
+		}
+	}
+
+	for name, on := range enabled {
+		if on {
+			enable(name)
+		}
+	}
+}
+
+// explicit tells whether the collector.<name> flag was given explicitly. r.mtx must be held.
+func (r *Registry) explicit(name string) bool {
+	return r.flagPassed != nil && r.flagPassed("collector."+name)
+}
+
+// profileCollectors resolves profile names to the collectors they enable. r.mtx must be held.
+func (r *Registry) profileCollectors(profiles []string) (map[string]bool, error) {
+
+	enabled := make(map[string]bool)
+
+	for _, profile := range profiles {
+
+		profile = strings.TrimSpace(profile)
+
+		if names, ok := r.profiles[profile]; ok {
+			for _, name := range names {
+				enabled[name] = true
+			}
+			continue
+		}
+
+		found := false
+
+		for name, reg := range r.registrations {
+			switch {
+			case profile == ProfileFull,
+				profile == ProfileDefault && reg.Default,
+				slices.Contains(reg.Tags, profile):
+				enabled[name] = true
+				found = true
+			}
+		}
+
+		if !found && profile != ProfileFull && profile != ProfileDefault {
+			return nil, fmt.Errorf("unknown collectors profile %q", profile)
+		}
+	}
+
+	return enabled, nil
+}
+
+// RegisterAPI sets the API the registry's collector sets log in with.
+func (r *Registry) RegisterAPI(clientAPI ClientAPI) {
+	r.RegisterNamedAPI("", clientAPI)
+}
+
+// RegisterNamedAPI is RegisterAPI for an API with a name, which collectors can ask for with Registration.API.
+func (r *Registry) RegisterNamedAPI(name string, clientAPI ClientAPI) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.clientAPI = clientAPI
+	r.apiName = name
+}
+
+// Registration describes a collector to Register.
+type Registration struct {
+	Name        string
+	Description string
+	// Default is whether the collector is enabled when nothing says otherwise.
+	Default bool
+	// Tags group collectors in help output and on the landing page. Each tag also works as a -collectors.profile.
+	Tags []string
+	// API is the name of the API the collector needs, as given to RegisterNamedAPI. Empty works with any.
+	API string
+	// DependsOn names collectors this one needs. They are enabled along with it unless explicitly disabled.
+	DependsOn []string
+	// Flag holds the enabled state. If nil, the default registry defines a collector.<Name> flag for it, other registries
+	// just use Default.
+	Flag    *bool
+	Factory Factory
+	// ScrapeFactory creates a collector taking a ScrapeContext. Set it instead of Factory.
+	ScrapeFactory func(logger *slog.Logger) (ScrapeCollector, error)
+}
+
+// Register adds a collector with its metadata. Registering the same name twice replaces the first registration; without a
+// Flag of its own the new one keeps the state (and for the default registry the collector.<name> flag) of the first.
+func (r *Registry) Register(reg Registration) {
+
+	if reg.Factory == nil && reg.ScrapeFactory != nil {
+		scrapeFactory := reg.ScrapeFactory
+		reg.Factory = func(logger *slog.Logger) (Collector, error) {
+			c, err := scrapeFactory(logger)
+			if err != nil {
+				return nil, err
+			}
+			return Adapt(c), nil
+		}
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if reg.Flag == nil {
+		// flag.Bool panics on a name that is already defined.
+		if state, ok := r.state[reg.Name]; ok {
+			reg.Flag = state
+		} else if r.commandLine {
+			reg.Flag = flag.Bool("collector."+reg.Name, reg.Default, registrationUsage(reg))
+		} else {
+			enabled := reg.Default
+			reg.Flag = &enabled
+		}
+	}
+
+	r.registrations[reg.Name] = reg
+	r.state[reg.Name] = reg.Flag
+	r.factories[reg.Name] = reg.Factory
+}
+
+func registrationUsage(reg Registration) string {
+
+	usage := fmt.Sprintf("Enable the %s collector", reg.Name)
+	if reg.Description != "" {
+		usage += ": " + reg.Description
+	}
+	if len(reg.Tags) > 0 {
+		usage += fmt.Sprintf(" (tags: %s)", strings.Join(reg.Tags, ", "))
+	}
+
+	return usage
+}
+
+// RegisterCollector adds a collector, enabled while *flag is true. Register takes a description, tags and the rest as well.
+func (r *Registry) RegisterCollector(collector string, flag *bool, factory Factory) {
+	r.Register(Registration{Name: collector, Default: *flag, Flag: flag, Factory: factory})
+}
+
+// RegisterProfile names a set of collectors for -collectors.profile. It wins over a tag with the same name.
+func (r *Registry) RegisterProfile(name string, collectors ...string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.profiles[name] = collectors
+}
+
+// CollectorInfo describes a registered collector for help and status pages.
+type CollectorInfo struct {
+	Name        string
+	Description string
+	Default     bool
+	Enabled     bool
+	Tags        []string
+	API         string
+	DependsOn   []string
+}
+
+// Collectors lists the registered collectors in name order, with the state their flags (and for the default registry
+// -collectors.profile and -disable.default.collectors) leave them in.
+func (r *Registry) Collectors() []CollectorInfo {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	// A bad profile shows up in Validate and on the first scrape, here the collectors just keep their flags.
+	enabled, err := r.enabledCollectors()
+	if err != nil {
+		enabled = make(map[string]bool, len(r.state))
+		for name, state := range r.state {
+			enabled[name] = *state
+		}
+	}
+
+	list := make([]CollectorInfo, 0, len(r.state))
+
+	for name := range r.state {
+		reg := r.registrations[name]
+		list = append(list, CollectorInfo{
+			Name:        name,
+			Description: reg.Description,
+			Default:     reg.Default,
+			Enabled:     enabled[name],
+			Tags:        reg.Tags,
+			API:         reg.API,
+			DependsOn:   reg.DependsOn,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// Untagged is the group collectors without tags are listed under.
+const Untagged = "untagged"
+
+// CollectorsByTag groups Collectors by tag, tags in name order. A collector with several tags shows up under each.
+func (r *Registry) CollectorsByTag() (tags []string, collectors map[string][]CollectorInfo) {
+
+	collectors = make(map[string][]CollectorInfo)
+
+	for _, info := range r.Collectors() {
+		infoTags := info.Tags
+		if len(infoTags) == 0 {
+			infoTags = []string{Untagged}
+		}
+		for _, tag := range infoTags {
+			if _, ok := collectors[tag]; !ok {
+				tags = append(tags, tag)
+			}
+			collectors[tag] = append(collectors[tag], info)
+		}
+	}
+
+	sort.Strings(tags)
+
+	return tags, collectors
+}
+
+// Profiles lists the profile names -collectors.profile takes: the built-in ones, registered ones and tags.
+func (r *Registry) Profiles() []string {
+
+	tags, _ := r.CollectorsByTag()
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	profiles := []string{ProfileDefault, ProfileFull}
+	for name := range r.profiles {
+		profiles = append(profiles, name)
+	}
+	for _, tag := range tags {
+		if tag != Untagged {
+			profiles = append(profiles, tag)
+		}
+	}
+
+	sort.Strings(profiles)
+
+	return slices.Compact(profiles)
+}
+
+// WriteHelp lists the collectors by tag with their state and description, and the profiles, for -help.
+func (r *Registry) WriteHelp(w io.Writer) {
+
+	tags, collectors := r.CollectorsByTag()
+
+	if len(tags) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "\nCollectors by tag:")
+
+	for _, tag := range tags {
+		fmt.Fprintf(w, "  %s:\n", tag)
+		for _, info := range collectors[tag] {
+			state := "disabled"
+			if info.Enabled {
+				state = "enabled"
+			}
+			fmt.Fprintf(w, "    %-24s %-9s %s\n", info.Name, state, info.Description)
+		}
+	}
+
+	fmt.Fprintf(w, "\nProfiles for -collectors.profile: %s\n", strings.Join(r.Profiles(), ", "))
+}
+
+// IsRegistered tells whether a collector with the given name has been registered.
+func (r *Registry) IsRegistered(collector string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	_, ok := r.factories[collector]
+	return ok
+}
+
+func (r *Registry) NewCollectorSet(namespace, target string, params map[string]string, logger *slog.Logger) (CollectorSet, error) {
+	return r.NewModuleCollectorSet(namespace, target, nil, params, logger)
+}
+
+// NewModuleCollectorSet creates a CollectorSet for a probe module. If the module lists collectors, exactly these are used
+// regardless of their flags, otherwise it falls back to the enabled ones. A nil module behaves as NewCollectorSet.
+func (r *Registry) NewModuleCollectorSet(namespace, target string, module *Module, params map[string]string, logger *slog.Logger) (CollectorSet, error) {
+	return r.newCollectorSet(namespace, target, module, paramsFromMap(params), logger, false)
+}
+
+// NewProbeCollectorSet is NewModuleCollectorSet with parameters as a ParamParser returns them, several values each if need be.
+func (r *Registry) NewProbeCollectorSet(namespace, target string, module *Module, params Params, logger *slog.Logger) (CollectorSet, error) {
+	return r.newCollectorSet(namespace, target, module, params, logger, false)
+}
+
+// NewDebugCollectorSet is NewProbeCollectorSet with collectors of its own, created for this set with logger instead of
+// the shared ones. Everything the set logs, the collectors included, goes to logger. Meant for one-off debug probes.
+func (r *Registry) NewDebugCollectorSet(namespace, target string, module *Module, params Params, logger *slog.Logger) (CollectorSet, error) {
+	return r.newCollectorSet(namespace, target, module, params, logger, true)
+}
+
+func (r *Registry) newCollectorSet(namespace, target string, module *Module, params Params, logger *slog.Logger, fresh bool) (CollectorSet, error) {
+
+	var sm ScrapeMetrics
+
+	sm.Duration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
+		"Duration of a collector scrape.",
+		[]string{"collector"},
+		nil,
+	)
+
+	sm.Success = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_success"),
+		"Whether a collector succeeded.",
+		[]string{"collector"},
+		nil,
+	)
+
+	collectors := make(map[string]Collector)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	state, err := r.enabledCollectors()
+	if err != nil {
+		return CollectorSet{}, err
+	}
+
+	if module != nil && len(module.Collectors) > 0 {
+		state = make(map[string]bool, len(module.Collectors))
+		for _, key := range module.Collectors {
+			if _, ok := r.factories[key]; !ok {
+				return CollectorSet{}, fmt.Errorf("module %s: unknown collector %s", module.Name, key)
+			}
+			state[key] = true
+		}
+		// What a listed collector depends on comes along, the module can't have meant it to be skipped.
+		r.enableDependencies(state)
+	}
+
+	for key, enabled := range state {
+
+		if !enabled {
+			logger.Debug("collector disabled", "name", key)
+			continue
+		}
+
+		logger.Debug("collector enabled", "name", key)
+
+		if collector, ok := r.initiated[key]; ok && !fresh {
+			collectors[key] = collector
+		} else {
+			collector, err := r.newCollector(key, logger, fresh)
+			if err != nil {
+				return CollectorSet{}, err
+			}
+
+			collectors[key] = collector
+
+		}
+
+	}
+
+	dependsOn := make(map[string][]string, len(collectors))
+	for key := range collectors {
+		dependsOn[key] = r.registrations[key].DependsOn
+	}
+
+	// Collect would wait forever on a cycle.
+	if cycle := findCycle(dependsOn); cycle != nil {
+		return CollectorSet{}, fmt.Errorf("collector dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	return CollectorSet{
+		Collectors:    collectors,
+		dependsOn:     dependsOn,
+		clientAPI:     r.clientAPI,
+		target:        target,
+		module:        module,
+		namespace:     namespace,
+		params:        params,
+		logger:        logging.ForTarget(logger, target),
+		ScrapeMetrics: sm,
+	}, nil
+}
+
+// newCollector runs the factory of a collector. Unless fresh, the collector is kept for every later set and the outcome is
+// recorded for Ready. r.mtx must be held.
+func (r *Registry) newCollector(key string, logger *slog.Logger, fresh bool) (Collector, error) {
+
+	var collector Collector
+	var err error
+
+	if api := r.registrations[key].API; api != "" && api != r.apiName {
+		err = fmt.Errorf("needs the %s API, the registered one is %q", api, r.apiName)
+	} else {
+		// Collectors are shared between targets, so their logger follows per-collector level overrides only. Login, logout
+		// and the scrape summary go through the set's own logger, which follows per-target ones too.
+		collector, err = r.factories[key](logger.With("collector", key))
+	}
+
+	if fresh {
+		return collector, err
+	}
+
+	if err != nil {
+		r.factoryErrors[key] = err
+		return nil, err
+	}
+
+	delete(r.factoryErrors, key)
+	r.initiated[key] = collector
+
+	return collector, nil
+}
+
+// Validate checks the registrations and the command line: dependencies have to be registered, free of cycles and not
+// disabled while a collector needing them is enabled, and -collectors.profile known.
+func (r *Registry) Validate() error {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	var errs []error
+
+	names := make([]string, 0, len(r.registrations))
+	for name := range r.registrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, dep := range r.registrations[name].DependsOn {
+			if _, ok := r.factories[dep]; !ok {
+				errs = append(errs, fmt.Errorf("collector %s depends on unknown collector %s", name, dep))
+			}
+		}
+	}
+
+	deps := make(map[string][]string, len(r.registrations))
+	for name, reg := range r.registrations {
+		deps[name] = reg.DependsOn
+	}
+	if cycle := findCycle(deps); cycle != nil {
+		errs = append(errs, fmt.Errorf("collector dependency cycle: %s", strings.Join(cycle, " -> ")))
+	}
+
+	enabled, err := r.enabledCollectors()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// Only an explicitly disabled dependency can be off here, and the collector would be skipped on every scrape.
+	for _, name := range names {
+		if !enabled[name] {
+			continue
+		}
+		for _, dep := range r.registrations[name].DependsOn {
+			if _, ok := r.factories[dep]; ok && !enabled[dep] {
+				errs = append(errs, fmt.Errorf("collector %s is enabled but its dependency %s is disabled", name, dep))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// InitCollectors creates every enabled collector up front rather than on the first scrape, so a failing factory shows up at
+// startup and in Ready.
+func (r *Registry) InitCollectors(logger *slog.Logger) error {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	state, err := r.enabledCollectors()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for key, enabled := range state {
+		if _, ok := r.initiated[key]; ok || !enabled {
+			continue
+		}
+		if _, err := r.newCollector(key, logger, false); err != nil {
+			errs = append(errs, fmt.Errorf("collector %s: %w", key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Ready reports the collectors whose factory failed the last time it ran.
+func (r *Registry) Ready() error {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	keys := make([]string, 0, len(r.factoryErrors))
+	for key := range r.factoryErrors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, fmt.Errorf("collector %s: %w", key, r.factoryErrors[key]))
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckLogin logs in to the default target (the empty one, as /metrics uses) and out again, to tell whether the upstream
+// can be reached with the configured credentials.
+func (r *Registry) CheckLogin(logger *slog.Logger) error {
+
+	r.mtx.Lock()
+	clientAPI := r.clientAPI
+	r.mtx.Unlock()
+
+	if clientAPI == nil {
+		return errors.New("no API registered")
+	}
+
+	clientData, err := clientAPI.Login("", logger)
+	if err != nil {
+		return err
+	}
+
+	return clientAPI.Logout(clientData, logger)
+}
+
+// The package level functions below work on DefaultRegistry.
+
+func RegisterAPI(clientAPI ClientAPI) {
+	DefaultRegistry.RegisterAPI(clientAPI)
+}
+
+// RegisterNamedAPI is Registry.RegisterNamedAPI for the default registry.
+func RegisterNamedAPI(name string, clientAPI ClientAPI) {
+	DefaultRegistry.RegisterNamedAPI(name, clientAPI)
+}
+
+func RegisterCollector(collector string, flag *bool, factory Factory) {
+	DefaultRegistry.RegisterCollector(collector, flag, factory)
+}
+
+// Register adds a collector with its metadata to the default registry, defining its collector.<name> flag unless
+// reg.Flag is set. Call it from init so the flag exists before the command line is parsed.
+func Register(reg Registration) {
+	DefaultRegistry.Register(reg)
+}
+
+// RegisterProfile is Registry.RegisterProfile for the default registry.
+func RegisterProfile(name string, collectors ...string) {
+	DefaultRegistry.RegisterProfile(name, collectors...)
+}
+
+// Collectors lists the collectors of the default registry.
+func Collectors() []CollectorInfo {
+	return DefaultRegistry.Collectors()
+}
+
+// IsRegistered tells whether a collector with the given name has been registered.
+func IsRegistered(collector string) bool {
+	return DefaultRegistry.IsRegistered(collector)
+}
+
+func NewCollectorSet(namespace, target string, params map[string]string, logger *slog.Logger) (CollectorSet, error) {
+	return DefaultRegistry.NewCollectorSet(namespace, target, params, logger)
+}
+
+// NewModuleCollectorSet is Registry.NewModuleCollectorSet for the default registry.
+func NewModuleCollectorSet(namespace, target string, module *Module, params map[string]string, logger *slog.Logger) (CollectorSet, error) {
+	return DefaultRegistry.NewModuleCollectorSet(namespace, target, module, params, logger)
+}
+
+// NewProbeCollectorSet is Registry.NewProbeCollectorSet for the default registry.
+func NewProbeCollectorSet(namespace, target string, module *Module, params Params, logger *slog.Logger) (CollectorSet, error) {
+	return DefaultRegistry.NewProbeCollectorSet(namespace, target, module, params, logger)
+}
+
+// NewDebugCollectorSet is Registry.NewDebugCollectorSet for the default registry.
+func NewDebugCollectorSet(namespace, target string, module *Module, params Params, logger *slog.Logger) (CollectorSet, error) {
+	return DefaultRegistry.NewDebugCollectorSet(namespace, target, module, params, logger)
+}
+
+// Validate is Registry.Validate for the default registry.
+func Validate() error {
+	return DefaultRegistry.Validate()
+}
+
+// InitCollectors is Registry.InitCollectors for the default registry.
+func InitCollectors(logger *slog.Logger) error {
+	return DefaultRegistry.InitCollectors(logger)
+}
+
+// Ready is Registry.Ready for the default registry.
+func Ready() error {
+	return DefaultRegistry.Ready()
+}
+
+// CheckLogin is Registry.CheckLogin for the default registry.
+func CheckLogin(logger *slog.Logger) error {
+	return DefaultRegistry.CheckLogin(logger)
+}
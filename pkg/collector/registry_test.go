@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func nopFactory(*slog.Logger) (Collector, error) { return nil, nil }
+
+// newCommandLineRegistry is a registry that follows the command line like DefaultRegistry, with passed standing in for
+// the flags given explicitly.
+func newCommandLineRegistry(passed map[string]bool) *Registry {
+	r := NewRegistry()
+	r.commandLine = true
+	r.flagPassed = func(name string) bool { return passed[name] }
+	return r
+}
+
+func TestEnabledCollectors(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		profile        string
+		disableDefault bool
+		// flags are the values of the collector flags, passed the ones given explicitly.
+		flags  map[string]bool
+		passed map[string]bool
+		want   map[string]bool
+	}{
+		{
+			name:  "defaults",
+			flags: map[string]bool{"a": true, "b": false, "c": false},
+			want:  map[string]bool{"a": true, "b": false, "c": false},
+		},
+		{
+			name:           "disable defaults",
+			disableDefault: true,
+			flags:          map[string]bool{"a": true, "b": true, "c": false},
+			passed:         map[string]bool{"collector.b": true},
+			want:           map[string]bool{"a": false, "b": true, "c": false},
+		},
+		{
+			name:    "profile by tag",
+			profile: "extra",
+			flags:   map[string]bool{"a": true, "b": false, "c": false},
+			want:    map[string]bool{"a": false, "b": false, "c": true},
+		},
+		{
+			name:    "explicit flags win over the profile",
+			profile: "extra",
+			flags:   map[string]bool{"a": true, "b": false, "c": false},
+			passed:  map[string]bool{"collector.a": true, "collector.c": true},
+			want:    map[string]bool{"a": true, "b": false, "c": false},
+		},
+		{
+			name:    "full",
+			profile: "full",
+			flags:   map[string]bool{"a": false, "b": false, "c": false},
+			want:    map[string]bool{"a": true, "b": true, "c": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			*collectorsProfile, *disableDefaultCollector = tt.profile, tt.disableDefault
+			defer func() { *collectorsProfile, *disableDefaultCollector = "", DefaultDisabled }()
+
+			r := newCommandLineRegistry(tt.passed)
+			for _, name := range []string{"a", "b", "c"} {
+				enabled := tt.flags[name]
+				reg := Registration{Name: name, Default: name == "a", Flag: &enabled, Factory: nopFactory}
+				if name == "c" {
+					reg.Tags = []string{"extra"}
+				}
+				r.Register(reg)
+			}
+
+			for range 2 {
+				got, err := r.enabledCollectors()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for name, want := range tt.want {
+					if got[name] != want {
+						t.Errorf("%s enabled = %t, want %t", name, got[name], want)
+					}
+				}
+			}
+
+			// Working it out must leave the flags as they are, or a reload could not bring a collector back.
+			for name, state := range r.state {
+				if *state != tt.flags[name] {
+					t.Errorf("flag of %s changed to %t", name, *state)
+				}
+			}
+		})
+	}
+}
+
+func TestEnabledCollectorsUnknownProfile(t *testing.T) {
+
+	*collectorsProfile = "nope"
+	defer func() { *collectorsProfile = "" }()
+
+	r := newCommandLineRegistry(nil)
+	r.Register(Registration{Name: "a", Factory: nopFactory})
+
+	if err := r.Validate(); err == nil {
+		t.Fatal("Validate() accepted an unknown profile")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+
+	r := NewRegistry()
+	r.Register(Registration{Name: "a", Default: true, Factory: nopFactory})
+	first := r.state["a"]
+
+	r.Register(Registration{Name: "a", Description: "again", Factory: nopFactory})
+
+	if r.state["a"] != first {
+		t.Fatal("registering again replaced the state of the first registration")
+	}
+	if r.registrations["a"].Description != "again" {
+		t.Fatal("registering again did not replace the registration")
+	}
+}
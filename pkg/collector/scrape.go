@@ -0,0 +1,231 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Params are the probe parameters of a scrape. A parameter can have several values.
+type Params map[string][]string
+
+// paramsFromMap turns the single valued parameters of Update into Params, leaving out empty ones.
+func paramsFromMap(m map[string]string) Params {
+	p := make(Params, len(m))
+	for name, value := range m {
+		if value != "" {
+			p[name] = []string{value}
+		}
+	}
+	return p
+}
+
+// Map returns the parameters the form Update gets them in, several values joined by commas.
+func (p Params) Map() map[string]string {
+	m := make(map[string]string, len(p))
+	for name, values := range p {
+		if len(values) > 0 {
+			m[name] = strings.Join(values, ",")
+		}
+	}
+	return m
+}
+
+// Get returns the first value of name, or "" if there is none.
+func (p Params) Get(name string) string {
+	if values := p[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns every value of name.
+func (p Params) Values(name string) []string {
+	return p[name]
+}
+
+// Has tells whether name was given.
+func (p Params) Has(name string) bool {
+	return len(p[name]) > 0
+}
+
+// Int, Float, Bool and Duration parse the first value of name, returning def if the parameter is not there.
+
+func (p Params) Int(name string, def int) (int, error) {
+	if !p.Has(name) {
+		return def, nil
+	}
+	v, err := strconv.Atoi(p.Get(name))
+	if err != nil {
+		return def, fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return v, nil
+}
+
+func (p Params) Float(name string, def float64) (float64, error) {
+	if !p.Has(name) {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(p.Get(name), 64)
+	if err != nil {
+		return def, fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return v, nil
+}
+
+func (p Params) Bool(name string, def bool) (bool, error) {
+	if !p.Has(name) {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(p.Get(name))
+	if err != nil {
+		return def, fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return v, nil
+}
+
+func (p Params) Duration(name string, def time.Duration) (time.Duration, error) {
+	if !p.Has(name) {
+		return def, nil
+	}
+	v, err := time.ParseDuration(p.Get(name))
+	if err != nil {
+		return def, fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// MetricSink takes the metrics a collector produces.
+type MetricSink interface {
+	Send(m prometheus.Metric)
+}
+
+// ChannelSink sends metrics down a channel, as Update gets them.
+type ChannelSink chan<- prometheus.Metric
+
+func (s ChannelSink) Send(m prometheus.Metric) {
+	s <- m
+}
+
+// ScrapeContext is everything a collector gets for one scrape. New fields can be added without touching collectors.
+type ScrapeContext struct {
+	// Context carries the request deadline and cancellation, and the trace.
+	Context   context.Context
+	Namespace string
+	Target    string
+	// Module is the probe module, nil if none applies.
+	Module *Module
+	Params Params
+	API    ClientAPI
+	// Session is what Login returned for this scrape.
+	Session map[string]any
+	Logger  *slog.Logger
+	// Store is the per-scrape cache shared by the collectors of the scrape, see Publish and Lookup.
+	Store   *Store
+	Metrics MetricSink
+
+	// Set by Collect on sampled scrapes, so Get can trace and count the calls of collector.
+	collector string
+	gets      *atomic.Int64
+}
+
+// Send passes a metric to the sink.
+func (sc *ScrapeContext) Send(m prometheus.Metric) {
+	sc.Metrics.Send(m)
+}
+
+// Gauge sends a gauge for desc. It panics on label mismatches like prometheus.MustNewConstMetric.
+func (sc *ScrapeContext) Gauge(desc *prometheus.Desc, value float64, labelValues ...string) {
+	sc.Send(prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labelValues...))
+}
+
+// Counter sends a counter for desc. It panics on label mismatches like prometheus.MustNewConstMetric.
+func (sc *ScrapeContext) Counter(desc *prometheus.Desc, value float64, labelValues ...string) {
+	sc.Send(prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, labelValues...))
+}
+
+// Get calls API.Get with the session of the scrape. On sampled scrapes the call gets a Get span of its own.
+func (sc *ScrapeContext) Get(extraConfig map[string]any) (any, error) {
+	if sc.gets != nil {
+		return sc.traceGet(extraConfig)
+	}
+	return sc.API.Get(sc.Session, extraConfig, sc.Logger)
+}
+
+// ScrapeCollector is a collector taking a ScrapeContext. Register it with Registration.ScrapeFactory.
+type ScrapeCollector interface {
+	Scrape(sc *ScrapeContext) error
+}
+
+// Adapt makes a ScrapeCollector a Collector, for code that calls Update itself. Collect calls Scrape directly.
+func Adapt(c ScrapeCollector) Collector {
+	return scrapeAdapter{c}
+}
+
+type scrapeAdapter struct {
+	ScrapeCollector
+}
+
+func (a scrapeAdapter) Update(ch chan<- prometheus.Metric, namespace string, clientAPI ClientAPI, clientData map[string]any, extraParams map[string]string) error {
+	return a.Scrape(&ScrapeContext{
+		Context:   context.Background(),
+		Namespace: namespace,
+		Target:    targetOf(clientData),
+		Params:    paramsFromMap(extraParams),
+		API:       clientAPI,
+		Session:   clientData,
+		Logger:    slog.Default(),
+		Store:     newStore(),
+		Metrics:   ChannelSink(ch),
+	})
+}
+
+func targetOf(clientData map[string]any) string {
+	target, _ := clientData["target"].(string)
+	return target
+}
+
+// UpdateAdapter makes a Collector with the old Update method a ScrapeCollector, so existing collectors keep working.
+// StoreCollectors get the scrape's store.
+func UpdateAdapter(c Collector) ScrapeCollector {
+	if sc, ok := c.(ScrapeCollector); ok {
+		return sc
+	}
+	return updateAdapter{c}
+}
+
+type updateAdapter struct {
+	c Collector
+}
+
+func (a updateAdapter) Scrape(sc *ScrapeContext) error {
+
+	ch, ok := sc.Metrics.(ChannelSink)
+	if !ok {
+		forward := make(chan prometheus.Metric)
+		done := make(chan struct{})
+		go func() {
+			for m := range forward {
+				sc.Send(m)
+			}
+			close(done)
+		}()
+		defer func() {
+			close(forward)
+			<-done
+		}()
+		ch = forward
+	}
+
+	if store, ok := a.c.(StoreCollector); ok {
+		return store.UpdateWithStore(sc.Store, ch, sc.Namespace, sc.API, sc.Session, sc.Params.Map())
+	}
+
+	return a.c.Update(ch, sc.Namespace, sc.API, sc.Session, sc.Params.Map())
+}
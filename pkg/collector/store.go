@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Store is where collectors of one scrape leave data for the collectors that depend on them. It lives for a single Collect.
+type Store struct {
+	mtx    sync.RWMutex
+	values map[string]any
+}
+
+func newStore() *Store {
+	return &Store{values: make(map[string]any)}
+}
+
+// Key names a value in a Store and fixes its type, so publisher and reader agree on both. Declare keys as package variables
+// next to the collector that publishes them.
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns the key for name. Names must be unique within an exporter; prefixing them with the collector name helps.
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+func (k Key[T]) String() string {
+	return k.name
+}
+
+// Publish stores value under key for the rest of the scrape. A nil store is ignored.
+func Publish[T any](s *Store, key Key[T], value T) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.values[key.name] = value
+}
+
+// Lookup returns the value published under key. Collectors declaring the publisher in DependsOn always find it if the
+// publisher succeeded and published.
+func Lookup[T any](s *Store, key Key[T]) (T, bool) {
+
+	var zero T
+	if s == nil {
+		return zero, false
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	value, ok := s.values[key.name].(T)
+	return value, ok
+}
+
+// StoreCollector is a Collector that shares data with other collectors of the same scrape. Collect calls UpdateWithStore instead
+// of Update, after every collector in its DependsOn has finished successfully.
+type StoreCollector interface {
+	Collector
+	UpdateWithStore(store *Store, ch chan<- prometheus.Metric, namespace string, clientAPI ClientAPI, clientData map[string]any, extraParams map[string]string) error
+}
+
+// findCycle returns a dependency cycle among deps, first name repeated at the end, or nil if there is none. Dependencies
+// outside deps are ignored.
+func findCycle(deps map[string][]string) []string {
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := make(map[string]int, len(deps))
+	var path []string
+	var cycle []string
+
+	var visit func(name string) bool
+	visit = func(name string) bool {
+
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; !ok {
+				continue
+			}
+			switch state[dep] {
+			case visiting:
+				for i, n := range path {
+					if n == dep {
+						cycle = append(append([]string{}, path[i:]...), dep)
+						break
+					}
+				}
+				return true
+			case unvisited:
+				if visit(dep) {
+					return true
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = done
+
+		return false
+	}
+
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if state[name] == unvisited && visit(name) {
+			return cycle
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/tracing"
+)
+
+// SetContext gives the set the context of the request it collects for. Its spans become children of the span in ctx.
+func (cs *CollectorSet) SetContext(ctx context.Context) {
+	cs.ctx = ctx
+}
+
+func (cs *CollectorSet) context() context.Context {
+	if cs.ctx == nil {
+		return context.Background()
+	}
+	return cs.ctx
+}
+
+// traceGet calls API.Get of sc in a Get span under the collector's Update span, counting the call.
+func (sc *ScrapeContext) traceGet(extraConfig map[string]any) (any, error) {
+
+	sc.gets.Add(1)
+
+	_, span := tracing.Start(sc.Context, "Get", tracing.KindClient, tracing.String("collector", sc.collector))
+	defer span.End()
+
+	data, err := sc.API.Get(sc.Session, extraConfig, sc.Logger)
+	span.SetError(err)
+
+	return data, err
+}
@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type recordingExporter struct {
+	mtx   sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *recordingExporter) ExportSpans(_ context.Context, spans []tracing.SpanData) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(context.Context) error { return nil }
+
+// updateFunc is a collector with the old Update method.
+type updateFunc func(clientAPI ClientAPI, clientData map[string]any) error
+
+func (f updateFunc) Update(ch chan<- prometheus.Metric, namespace string, clientAPI ClientAPI, clientData map[string]any, params map[string]string) error {
+	return f(clientAPI, clientData)
+}
+
+func TestCollectTracedKeepsAPI(t *testing.T) {
+
+	exporter := &recordingExporter{}
+	tracing.SetExporter(exporter, discard)
+
+	var asserted bool
+
+	r := NewRegistry()
+	r.RegisterAPI(fakeAPI{})
+	r.Register(Registration{Name: "typed", Default: true, ScrapeFactory: func(*slog.Logger) (ScrapeCollector, error) {
+		return scrapeFunc(func(sc *ScrapeContext) error {
+			_, asserted = sc.API.(fakeAPI)
+			_, err := sc.Get(nil)
+			return err
+		}), nil
+	}})
+	r.Register(Registration{Name: "legacy", Default: true, Factory: func(*slog.Logger) (Collector, error) {
+		return updateFunc(func(clientAPI ClientAPI, clientData map[string]any) error {
+			_, err := clientAPI.Get(clientData, nil, discard)
+			return err
+		}), nil
+	}})
+
+	cs, err := r.NewProbeCollectorSet("test", "target", nil, nil, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		cs.Collect(ch)
+		close(ch)
+	}()
+	for range ch {
+	}
+
+	// Shutting down flushes the spans.
+	tracing.Shutdown(context.Background())
+
+	if !asserted {
+		t.Fatal("the collector did not get the API it registered on a sampled scrape")
+	}
+
+	exporter.mtx.Lock()
+	defer exporter.mtx.Unlock()
+
+	var gets int
+	for _, span := range exporter.spans {
+		if span.Name == "Get" {
+			gets++
+		}
+		if span.Name == "Update" {
+			var collector string
+			var getCalls any
+			for _, attr := range span.Attributes {
+				switch attr.Key {
+				case "collector":
+					collector, _ = attr.Value.(string)
+				case "get_calls":
+					getCalls = attr.Value
+				}
+			}
+			// Calls the Update collector makes on the API directly can't be counted, so it gets no count at all.
+			if want := map[string]any{"typed": int64(1), "legacy": nil}[collector]; getCalls != want {
+				t.Errorf("collector %s: get_calls = %v, want %v", collector, getCalls, want)
+			}
+		}
+	}
+	if gets != 1 {
+		t.Fatalf("recorded %d Get spans, want 1", gets)
+	}
+}
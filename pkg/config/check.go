@@ -0,0 +1,264 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	"gopkg.in/yaml.v3"
+)
+
+// Require marks a command line flag as mandatory. Parse fails and -config.check complains if it ends up empty.
+func Require(name string) {
+	RequireFlagSet(flag.CommandLine, name)
+}
+
+// RequireFlagSet is Require for a flag of fs.
+func RequireFlagSet(fs *flag.FlagSet, name string) {
+
+	st := stateOf(fs)
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	st.required[name] = true
+}
+
+// RegisterCheck has check vet the configuration whenever it is loaded - at startup, on each reload and with -config.check -
+// before any of it is applied. value tells what a flag is going to be, in the form its Set takes. An error fails the load,
+// and a reload keeps the previous configuration. Use it for flag values that only make sense together or need more parsing
+// than their flag.Value does. A configuration loaded already is checked right away.
+func RegisterCheck(check func(value func(name string) string) error) error {
+	return RegisterCheckFlagSet(flag.CommandLine, check)
+}
+
+// RegisterCheckFlagSet is RegisterCheck for the configuration of fs.
+func RegisterCheckFlagSet(fs *flag.FlagSet, check func(value func(name string) string) error) error {
+
+	st := stateOf(fs)
+
+	st.mtx.Lock()
+	st.checks = append(st.checks, check)
+	st.mtx.Unlock()
+
+	if !LoadedFlagSet(fs) {
+		return nil
+	}
+
+	release := HoldFlagSet(fs)
+	defer release()
+
+	return check(func(name string) string {
+		if f := fs.Lookup(name); f != nil {
+			return f.Value.String()
+		}
+		return ""
+	})
+}
+
+// runChecks runs the registered checks on what value says the flags are going to be.
+func (st *flagSetState) runChecks(value func(name string) string) []Problem {
+
+	st.mtx.RLock()
+	checks := st.checks
+	st.mtx.RUnlock()
+
+	problems := []Problem{}
+	for _, check := range checks {
+		if err := check(value); err != nil {
+			problems = append(problems, Problem{Source: "configuration", Message: err.Error()})
+		}
+	}
+
+	return problems
+}
+
+// Problem is something wrong with the configuration, with where it was found.
+type Problem struct {
+	Source  string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.Source, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Source, p.Message)
+}
+
+// Check looks at the configuration file, the environment and the command line the same way Parse does, but only reports what is
+// wrong instead of applying it: unknown keys, values a flag won't accept, required flags left empty, bad modules and a bad
+// -web.config.file.
+func Check() []Problem {
+	return CheckFlagSet(flag.CommandLine, os.LookupEnv)
+}
+
+// CheckFlagSet is Check for an already parsed flag set, with lookupEnv standing in for the environment. It goes through the
+// very steps ParseFlagSet does, only without applying anything.
+func CheckFlagSet(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) []Problem {
+
+	lp, problems := newParser(fs, lookupEnv).plan()
+	if lp == nil {
+		return problems
+	}
+
+	if path := lp.value(webConfigFileFlag); path != "" {
+		if err := web.Validate(path); err != nil {
+			problems = append(problems, Problem{Source: path, Message: err.Error()})
+		}
+	}
+
+	return problems
+}
+
+// sortProblems orders problems by where they were found.
+func sortProblems(problems []Problem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Source != problems[j].Source {
+			return problems[i].Source < problems[j].Source
+		}
+		return problems[i].Line < problems[j].Line
+	})
+}
+
+// runCheck prints the problems Check finds and returns the exit status for -config.check.
+func runCheck(w io.Writer) int {
+
+	problems := Check()
+
+	for _, p := range problems {
+		fmt.Fprintln(w, p)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(w, "configuration check failed: %d problem(s)\n", len(problems))
+		return 1
+	}
+
+	fmt.Fprintln(w, "configuration OK")
+	return 0
+}
+
+// WriteSchema writes a JSON Schema for the -file configuration: every command line flag and registered section.
+func WriteSchema(w io.Writer) error {
+	return WriteFlagSetSchema(w, flag.CommandLine)
+}
+
+// WriteFlagSetSchema writes a JSON Schema for a -file configuration of fs. A flag can be written with its full dotted name or
+// nested by name segment, collector.test: true or collector: {test: true}, and the schema takes both. Nothing is required,
+// since any flag may come from the command line or the environment instead.
+func WriteFlagSetSchema(w io.Writer, fs *flag.FlagSet) error {
+
+	keys := make(map[string]map[string]any)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		keys[f.Name] = flagSchema(f)
+	})
+
+	for name, s := range stateOf(fs).allSections() {
+		keys[name] = sectionSchema(name, s)
+	}
+
+	schema := objectSchema(keys)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(schema)
+}
+
+// objectSchema describes a mapping holding keys, given by their dotted names relative to it. Each key can be given in full,
+// or as a nested mapping under its first name segment - unless that segment is a key itself, which the file reader then
+// takes it for.
+func objectSchema(keys map[string]map[string]any) map[string]any {
+
+	properties := make(map[string]any, len(keys))
+	nested := make(map[string]map[string]map[string]any)
+
+	for name, schema := range keys {
+
+		properties[name] = schema
+
+		if first, rest, ok := strings.Cut(name, "."); ok {
+			if nested[first] == nil {
+				nested[first] = make(map[string]map[string]any)
+			}
+			nested[first][rest] = schema
+		}
+	}
+
+	for first, children := range nested {
+		if _, ok := keys[first]; !ok {
+			properties[first] = objectSchema(children)
+		}
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// sectionSchema describes a registered section. Only its shape is known, the fields are up to the exporter's own type.
+func sectionSchema(name string, s *section) map[string]any {
+
+	schema := map[string]any{
+		"description": fmt.Sprintf("Section %s of the exporter's own configuration.", name),
+	}
+
+	switch s.defaults.Kind() {
+	case reflect.Struct, reflect.Map:
+		schema["type"] = "object"
+	case reflect.Slice, reflect.Array:
+		schema["type"] = "array"
+	}
+
+	return schema
+}
+
+// flagSchema describes a single flag. The JSON type comes from the flag's Go type where it can be told, string otherwise.
+func flagSchema(f *flag.Flag) map[string]any {
+
+	schema := map[string]any{
+		"description": f.Usage,
+		"type":        "string",
+	}
+
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return schema
+	}
+
+	switch getter.Get().(type) {
+	case []string:
+		schema["type"] = []string{"array", "string"}
+		schema["items"] = map[string]any{"type": "string"}
+	case bool:
+		schema["type"] = "boolean"
+	case int, int64, uint, uint64:
+		schema["type"] = "integer"
+	case float64:
+		schema["type"] = "number"
+	case time.Duration:
+		schema["pattern"] = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$|^0$`
+	}
+
+	var def any
+	if err := yaml.Unmarshal([]byte(f.DefValue), &def); err == nil && def != nil && schema["type"] != "string" {
+		schema["default"] = def
+	} else if f.DefValue != "" {
+		schema["default"] = f.DefValue
+	}
+
+	return schema
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckAgreesWithParse(t *testing.T) {
+
+	tests := []struct {
+		name string
+		file string
+		args []string
+		env  map[string]string
+	}{
+		{"env enabled in the file, bad env value", "envflag.enable: true\n", nil, map[string]string{"num": "notanint"}},
+		{"env enabled in the file, good env value", "envflag.enable: true\n", nil, map[string]string{"num": "3"}},
+		{"env disabled, bad env value", "api.server: a\n", nil, map[string]string{"num": "notanint"}},
+		{"env enabled on the command line", "api.server: a\n", []string{"-envflag.enable"}, map[string]string{"num": "notanint"}},
+		{"bad file value", "num: x\n", nil, nil},
+		{"modules file set in the file", "config.modules: /does/not/exist.yml\n", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			path := writeFile(t, t.TempDir(), "config.yml", tt.file)
+			args := append(tt.args, "-file", path)
+
+			check, _, _ := newTestFlagSet()
+			check.Int("num", 0, "")
+			if err := check.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			problems := CheckFlagSet(check, env(tt.env))
+
+			parse, _, _ := newTestFlagSet()
+			parse.Int("num", 0, "")
+			err := ParseFlagSet(parse, args, env(tt.env))
+
+			if (len(problems) == 0) != (err == nil) {
+				t.Fatalf("check found %v, parse returned %v", problems, err)
+			}
+		})
+	}
+}
+
+func TestParseLeavesCheckToTheCaller(t *testing.T) {
+
+	path := writeFile(t, t.TempDir(), "config.yml", "api.srever: typo\n")
+
+	for _, mode := range []string{"-config.check", "-config.schema"} {
+		fs, _, _ := newTestFlagSet()
+		if err := ParseFlagSet(fs, []string{mode, "-file", path}, env(nil)); err != nil {
+			t.Fatalf("ParseFlagSet(%s) = %v, want the broken file left to the check", mode, err)
+		}
+		if LoadedFlagSet(fs) {
+			t.Fatalf("ParseFlagSet(%s) loaded the configuration", mode)
+		}
+		if problems := CheckFlagSet(fs, env(nil)); len(problems) != 1 {
+			t.Fatalf("check found %v, want the unknown key", problems)
+		}
+	}
+}
+
+func TestReloadFollowsEnvironment(t *testing.T) {
+
+	dir := t.TempDir()
+	path := writeFile(t, dir, ".env", "api_server=from-env\n")
+
+	fs, server, _ := newTestFlagSet()
+	if err := ParseFlagSet(fs, []string{"-envflag.enable", "-envflag.file", path}, env(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if *server != "from-env" {
+		t.Fatalf("api.server = %q, want from-env", *server)
+	}
+
+	// Gone from the .env file, back to the default.
+	writeFile(t, dir, ".env", "")
+	if err := ReloadFlagSet(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *server != "" || IsSetFlagSet(fs, "api.server") {
+		t.Fatalf("api.server = %q after it left the environment, want the default", *server)
+	}
+}
+
+func TestSchemaTakesNestedKeysAndSections(t *testing.T) {
+
+	fs, _, _ := newTestFlagSet()
+	RequireFlagSet(fs, "api.server")
+
+	var settings struct{ Name string }
+	RegisterSectionFlagSet(fs, "exporter.settings", &settings)
+
+	var buf bytes.Buffer
+	if err := WriteFlagSetSchema(&buf, fs); err != nil {
+		t.Fatal(err)
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := schema["required"]; ok {
+		t.Error("the schema requires flags the environment or command line may set")
+	}
+
+	// property follows a path of property names through nested objects.
+	property := func(path ...string) map[string]any {
+		node := schema
+		for _, name := range path {
+			properties, _ := node["properties"].(map[string]any)
+			node, _ = properties[name].(map[string]any)
+			if node == nil {
+				t.Fatalf("no property %v in the schema", path)
+			}
+		}
+		return node
+	}
+
+	for _, path := range [][]string{
+		{"api.server"},
+		{"api", "server"},
+		{"envflag", "enable"},
+		{"exporter.settings"},
+		{"exporter", "settings"},
+	} {
+		property(path...)
+	}
+
+	if typ := property("api", "server")["type"]; typ != "string" {
+		t.Errorf("api.server nested has type %v, want string", typ)
+	}
+	if typ := property("envflag", "enable")["type"]; typ != "boolean" {
+		t.Errorf("envflag.enable nested has type %v, want boolean", typ)
+	}
+	if typ := property("exporter", "settings")["type"]; typ != "object" {
+		t.Errorf("section exporter.settings has type %v, want object", typ)
+	}
+	if property("api")["additionalProperties"] != false {
+		t.Error("nested objects take unknown keys")
+	}
+}
+
+func TestRegisterCheck(t *testing.T) {
+
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yml", "api.server: good\n")
+
+	fs, server, _ := newTestFlagSet()
+
+	noBad := func(value func(string) string) error {
+		if value("api.server") == "bad" {
+			return errors.New("api.server must not be bad")
+		}
+		return nil
+	}
+
+	if err := RegisterCheckFlagSet(fs, noBad); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ParseFlagSet(fs, []string{"-file", path}, env(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, dir, "config.yml", "api.server: bad\n")
+
+	check, _, _ := newTestFlagSet()
+	if err := RegisterCheckFlagSet(check, noBad); err != nil {
+		t.Fatal(err)
+	}
+	if err := check.Parse([]string{"-file", path}); err != nil {
+		t.Fatal(err)
+	}
+	if problems := CheckFlagSet(check, env(nil)); len(problems) != 1 {
+		t.Errorf("check found %v, want the registered check to fail", problems)
+	}
+
+	if err := ReloadFlagSet(fs); err == nil || !strings.Contains(err.Error(), "must not be bad") {
+		t.Fatalf("ReloadFlagSet() = %v, want the registered check to fail it", err)
+	}
+	if *server != "good" {
+		t.Fatalf("api.server = %q after a rejected reload, want good", *server)
+	}
+
+	// Registered late, the check runs on what is loaded right away.
+	if err := RegisterCheckFlagSet(fs, func(value func(string) string) error {
+		if value("api.server") != "good" {
+			return errors.New("not the loaded value")
+		}
+		return errors.New("checked")
+	}); err == nil || err.Error() != "checked" {
+		t.Fatalf("RegisterCheckFlagSet() = %v, want the loaded configuration checked", err)
+	}
+}
@@ -4,92 +4,475 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prezhdarov/prometheus-exporter/pkg/logging"
+	"github.com/prezhdarov/prometheus-exporter/pkg/secret"
 
 	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/exporter-toolkit/web"
-	"gopkg.in/yaml.v3"
 )
 
-var (
-	enable = flag.Bool("envflag.enable", false, "Whether to enable reading flags from environment variables additionally to command line. "+
+// Names of the flags the config package itself reads. RegisterFlags defines them.
+const (
+	envEnableFlag = "envflag.enable"
+	envPrefixFlag = "envflag.prefix"
+	envFileFlag   = "envflag.file"
+	fileFlag      = "file"
+	flagsDirFlag  = "config.flags.dir"
+	modulesFlag   = "config.modules"
+	checkFlag     = "config.check"
+	schemaFlag    = "config.schema"
+	printFlag     = "config.print"
+	printFlagName = "print-config"
+
+	expandFlag       = "config.expand.env"
+	expandStrictFlag = "config.expand.strict"
+
+	webListenAddressFlag = "web.listen-address"
+	webSystemdSocketFlag = "web.systemd-socket"
+	webConfigFileFlag    = "web.config.file"
+)
+
+func init() {
+	RegisterFlags(flag.CommandLine)
+}
+
+// RegisterFlags defines the flags the config package works with (-file, -envflag.*, -config.*, -web.*) on fs. The command line
+// flag set has them already; call it for any other flag set handed to ParseFlagSet.
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.Bool(envEnableFlag, false, "Whether to enable reading flags from environment variables additionally to command line. "+
 		"Command line flag and file values (if -file is set) have priority over values from environment vars. "+
 		"Flags are read only from command line if this flag isn't set.")
-	prefix = flag.String("envflag.prefix", "", "Prefix for environment variables if -envflag.enable is set")
+	fs.String(envPrefixFlag, "", "Prefix for environment variables if -envflag.enable is set")
+	fs.String(envFileFlag, "", "Path to a .env file with NAME=value lines, read like environment variables if -envflag.enable is set. Real environment variables win.")
 
-	file = flag.String("file", "", "Path to file with configuration data.")
-)
+	fs.Var(NewStringList(), fileFlag, "Path to file with configuration data, or to a conf.d directory whose *.yml and *.yaml files are read in name order. "+
+		"May be repeated - later files override earlier ones.")
+	fs.String(flagsDirFlag, "", "Path to a directory with one file per flag, named after the flag and holding its value (e.g. a mounted Kubernetes ConfigMap). "+
+		"Overrides -file values.")
 
-func Parse() {
+	fs.String(modulesFlag, "", "Path to YAML file with /probe modules, selected with the module parameter.")
 
-	flag.Parse()
+	fs.Bool(expandFlag, false, "Expand ${VAR} and ${VAR:-default} in configuration and modules file values with environment variables. Write $${ for a literal ${. Off by default, so values that happen to contain ${, like passwords, are taken as they are.")
+	fs.Bool(expandStrictFlag, false, "Fail on ${VAR} references to environment variables that are not set, instead of expanding them to nothing.")
 
-	// Get all flags set on the command line
-	flagsSet := make(map[string]bool)
-	flag.Visit(func(f *flag.Flag) {
+	fs.Bool(checkFlag, false, "Check the configuration file, environment and flags, report any problems and exit with status 1 if there are any.")
+	fs.Bool(schemaFlag, false, "Print a JSON Schema describing every flag, for editor validation of the -file configuration, and exit.")
+	fs.Bool(printFlag, false, "Print the effective configuration with the source of every value, sensitive values redacted, and exit.")
+	fs.Var(fs.Lookup(printFlag).Value, printFlagName, "Same as -"+printFlag+".")
+
+	StringListVar(fs, NewStringList(), webListenAddressFlag, "Address to listen on for web interface and telemetry, host:port for TCP or unix:///path/to/socket for a unix socket. "+
+		"May be repeated. Overrides the exporter's own address flag.")
+	fs.Bool(webSystemdSocketFlag, false, "Use systemd socket activation listeners instead of port listeners.")
+	fs.String(webConfigFileFlag, "", "Path to configuration file that can enable TLS or authentication. See https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md")
+}
 
-		flagsSet[f.Name] = true
+// ParseError is everything that was wrong with a configuration, returned by ParseFlagSet.
+type ParseError struct {
+	Problems []Problem
+}
+
+func (e *ParseError) Error() string {
+
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, p.String())
+	}
+
+	return "invalid configuration: " + strings.Join(lines, "; ")
+}
+
+// parser keeps what a flag set was parsed with and where its values came from, so Reload knows what it may change.
+type parser struct {
+	fs        *flag.FlagSet
+	lookupEnv func(string) (string, bool)
+	cliFlags  map[string]bool
+
+	// sources tells where each flag not at its default got its value from, layerFlags which of them came from a file or the
+	// environment, the ones a reload may change. Reload updates both while /config reads them.
+	sourcesMtx sync.RWMutex
+	sources    map[string]string
+	layerFlags map[string]bool
+}
 
+func newParser(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) *parser {
+
+	if lookupEnv == nil {
+		lookupEnv = func(string) (string, bool) { return "", false }
+	}
+
+	p := &parser{
+		fs:         fs,
+		lookupEnv:  lookupEnv,
+		cliFlags:   make(map[string]bool),
+		sources:    make(map[string]string),
+		layerFlags: make(map[string]bool),
+	}
+
+	// Get all flags set on the command line
+	fs.Visit(func(f *flag.Flag) {
+		p.cliFlags[f.Name] = true
+		p.sources[f.Name] = SourceCommandLine
 	})
 
-	var fileFlags map[string]string
+	return p
+}
+
+// Parse parses the command line, then fills every flag not given there from the -file configuration and, if -envflag.enable is
+// set, from the environment. It handles -config.check, -config.schema and -config.print and exits the process on any problem.
+// It is a thin wrapper around ParseFlagSet for flag.CommandLine.
+func Parse() {
 
-	//If file set, read the thing
-	if *file != "" {
+	// flag.CommandLine exits on bad flags itself, like flag.Parse.
+	if err := ParseFlagSet(flag.CommandLine, os.Args[1:], os.LookupEnv); err != nil {
+		log.Fatalf("%s", err)
+	}
 
-		content, err := os.ReadFile(*file)
-		if err != nil {
-			log.Fatalf("cannot read file %s - error: %s", *file, err)
+	p := newParser(flag.CommandLine, nil)
+
+	switch {
+	case p.boolValue(schemaFlag):
+		if err := WriteSchema(os.Stdout); err != nil {
+			log.Fatalf("cannot write schema: %s", err)
+		}
+		os.Exit(0)
+
+	case p.boolValue(checkFlag):
+		os.Exit(runCheck(os.Stdout))
+
+	case p.boolValue(printFlag):
+		if err := WriteEffective(os.Stdout); err != nil {
+			log.Fatalf("cannot write configuration: %s", err)
+		}
+		os.Exit(0)
+	}
+}
+
+// ParseFlagSet parses args into fs and then fills every flag not given in args from the -file configuration and, if
+// -envflag.enable is set, from lookupEnv (os.LookupEnv or anything else, nil for none). Errors from fs.Parse are returned
+// as they are, everything else as a *ParseError listing all problems found. fs should have the config flags from RegisterFlags.
+// With -config.schema or -config.check given in args it stops after fs.Parse: writing the schema or checking the
+// configuration, with WriteFlagSetSchema or CheckFlagSet, is up to the caller, and neither should depend on it loading.
+func ParseFlagSet(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) error {
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	p := newParser(fs, lookupEnv)
+
+	if p.boolValue(schemaFlag) || p.boolValue(checkFlag) {
+		return nil
+	}
+
+	return p.load()
+}
+
+// load applies the configuration layers on top of the command line and remembers the parser for Reload. From highest to lowest
+// precedence the layers are: command line, -config.flags.dir, -file files (later ones first), environment variables and
+// finally the -envflag.file .env file. The last two only with -envflag.enable. Every value, section and the modules file are
+// checked first; on any problem nothing is applied.
+func (p *parser) load() error {
+
+	lp, problems := p.plan()
+	if len(problems) > 0 {
+		return &ParseError{Problems: problems}
+	}
+
+	if err := p.apply(lp); err != nil {
+		return err
+	}
+
+	st := stateOf(p.fs)
+
+	st.mtx.Lock()
+	st.parser = p
+	st.mtx.Unlock()
+
+	if p.fs == flag.CommandLine {
+		collector.DefaultRegistry.SetFlagPassed(IsSet)
+	}
+
+	return nil
+}
+
+// loadPlan is what a load or reload changes: the new value of every flag it touches and where that comes from, the sections
+// and the modules. It is worked out and checked in full before any of it is applied.
+type loadPlan struct {
+	p        *parser
+	fc       *fileConfig
+	updates  map[string]string
+	sources  map[string]string
+	sections map[string]reflect.Value
+	modules  *ModulesConfig
+}
+
+// value is what flag name will be once the plan is applied.
+func (lp *loadPlan) value(name string) string {
+	if v, ok := lp.updates[name]; ok {
+		return v
+	}
+	return lp.p.stringValue(name)
+}
+
+// plan reads the configuration layers and works out what applying them would change, without changing anything. It is the
+// one place the precedence rules live: load, Reload and -config.check all go through it. Flags not given on the command
+// line take their file value, failing that their environment value with -envflag.enable (from the command line or a file),
+// and failing both go back to their default if an earlier load set them from a file or the environment. Every problem found
+// is returned; the plan is only good to apply without any.
+func (p *parser) plan() (*loadPlan, []Problem) {
+
+	problems := []Problem{}
+
+	fc, err := p.readLayers()
+	if err != nil {
+		return nil, []Problem{{Source: "configuration", Message: err.Error()}}
+	}
+
+	// Unknown keys, values that cannot be expanded and malformed ones.
+	problems = append(problems, fc.problems...)
+
+	lp := &loadPlan{p: p, fc: fc, updates: make(map[string]string), sources: make(map[string]string)}
+
+	p.fs.VisitAll(func(f *flag.Flag) {
+
+		if p.cliFlags[f.Name] {
+			return
 		}
 
-		if err = yaml.Unmarshal(content, &fileFlags); err != nil {
-			log.Fatalf("cannot read contents of %s - error: %s", *file, err)
+		if value, ok := fc.values[f.Name]; ok {
+			lp.updates[f.Name] = value
+			lp.sources[f.Name] = fc.origins[f.Name].String()
 		}
+	})
 
-		//Now see if any of the flags are already set and if not if there's flags in the file.
-		flag.VisitAll(func(f *flag.Flag) {
+	// The env flags themselves may come from the files.
+	if lp.value(envEnableFlag) == "true" {
 
-			if flagsSet[f.Name] {
+		envFile, prefix := lp.value(envFileFlag), lp.value(envPrefixFlag)
+
+		lookupEnv, err := p.envLookupFile(envFile)
+		if err != nil {
+			return nil, []Problem{{Source: "configuration", Message: err.Error()}}
+		}
+
+		//Finally for all flags that are not set yet, see if there's corresponding env flag set and get it.
+		p.fs.VisitAll(func(f *flag.Flag) {
+
+			if _, ok := lp.updates[f.Name]; ok || p.cliFlags[f.Name] {
 				return
 			}
 
-			if _, exists := fileFlags[f.Name]; exists {
+			fname := envName(prefix, f.Name)
+			if v, ok := lookupEnv(fname); ok {
+				lp.updates[f.Name] = v
+				lp.sources[f.Name] = p.envSourceFile(fname, envFile)
+			}
+		})
+	}
 
-				if err := flag.Set(f.Name, fileFlags[f.Name]); err != nil {
-					log.Fatalf("cannot set flag %s to %q, which is read from environment variable %q: %s", f.Name, fileFlags[f.Name], f.Name, err)
-					return
-				}
-				flagsSet[f.Name] = true
+	// Whatever a file or the environment set last time and neither sets now goes back to its default.
+	p.sourcesMtx.RLock()
+	for name := range p.layerFlags {
+		if _, ok := lp.updates[name]; !ok {
+			lp.updates[name] = p.fs.Lookup(name).DefValue
+			lp.sources[name] = ""
+		}
+	}
+	p.sourcesMtx.RUnlock()
+
+	for name, v := range lp.updates {
+		if err := validateFlagValue(p.fs.Lookup(name), v); err != nil {
+			problem := Problem{Source: lp.sources[name], Message: fmt.Sprintf("cannot set flag %s to %q: %s", name, v, err)}
+			if _, inFile := fc.origins[name]; inFile && fc.values[name] == v {
+				problem = fc.problem(name, problem.Message)
 			}
+			problems = append(problems, problem)
+		}
+	}
 
-		})
+	for _, name := range stateOf(p.fs).requiredNames() {
+		if p.fs.Lookup(name) != nil && lp.value(name) == "" {
+			problems = append(problems, Problem{Source: "configuration", Message: fmt.Sprintf("required flag %s is not set", name)})
+		}
+	}
+
+	problems = append(problems, stateOf(p.fs).runChecks(lp.value)...)
+
+	var sectionProblems []Problem
+	lp.sections, sectionProblems = fc.decodeSections()
+	problems = append(problems, sectionProblems...)
 
+	if path := lp.value(modulesFlag); path != "" {
+		if lp.modules, err = loadModules(path, p.expander(), stateOf(p.fs).collectorRegistry()); err != nil {
+			problems = append(problems, Problem{Source: path, Message: err.Error()})
+		} else if err := stateOf(p.fs).checkModules(lp.modules); err != nil {
+			problems = append(problems, Problem{Source: path, Message: err.Error()})
+		}
 	}
 
-	if *enable {
+	sortProblems(problems)
 
-		//Finally for all flags that are not set yet, see if there's corresponding env flag set and get it.
-		flag.VisitAll(func(f *flag.Flag) {
+	return lp, problems
+}
 
-			if flagsSet[f.Name] {
+// apply makes a plan without problems the configuration of the flag set, waiting for the holders of HoldFlagSet first.
+func (p *parser) apply(lp *loadPlan) error {
 
-				return
+	st := stateOf(p.fs)
 
-			}
-			fname := getEnvFlagName(f.Name)
-			if v, ok := os.LookupEnv(fname); ok {
+	st.values.Lock()
+	defer st.values.Unlock()
 
-				if err := flag.Set(f.Name, v); err != nil {
+	p.sourcesMtx.Lock()
+	defer p.sourcesMtx.Unlock()
 
-					log.Fatalf("cannot set flag %s to %q, which is read from environment variable %q: %s", f.Name, v, fname, err)
+	for name, v := range lp.updates {
+		if r, ok := p.fs.Lookup(name).Value.(interface{ Reset() }); ok {
+			r.Reset()
+		}
+		if err := p.fs.Set(name, v); err != nil {
+			return fmt.Errorf("cannot set flag %s to %q: %w", name, v, err)
+		}
+		if lp.sources[name] == "" {
+			delete(p.sources, name)
+			delete(p.layerFlags, name)
+		} else {
+			p.sources[name] = lp.sources[name]
+			p.layerFlags[name] = true
+		}
+	}
 
-				}
+	if len(p.listValue(fileFlag)) > 0 {
+		applySections(p.fs, lp.sections)
+	}
 
-			}
-		})
+	SetModulesFlagSet(p.fs, lp.modules)
+
+	secret.Invalidate()
+
+	return nil
+}
+
+// envLookup returns the environment lookup with the -envflag.file .env file underneath it.
+func (p *parser) envLookup() (func(string) (string, bool), error) {
+	return p.envLookupFile(p.stringValue(envFileFlag))
+}
+
+// envLookupFile is envLookup with the .env file at path, none if empty.
+func (p *parser) envLookupFile(path string) (func(string) (string, bool), error) {
+
+	if path == "" {
+		return p.lookupEnv, nil
 	}
 
+	dotEnv, err := readDotEnv(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(name string) (string, bool) {
+		if v, ok := p.lookupEnv(name); ok {
+			return v, true
+		}
+		v, ok := dotEnv[name]
+		return v, ok
+	}, nil
+}
+
+// envSource describes where an environment value came from: the real environment or the -envflag.file file.
+func (p *parser) envSource(name string) string {
+	return p.envSourceFile(name, p.stringValue(envFileFlag))
+}
+
+func (p *parser) envSourceFile(name, path string) string {
+	if _, ok := p.lookupEnv(name); ok {
+		return "environment variable " + name
+	}
+	return fmt.Sprintf("env file %s (%s)", path, name)
+}
+
+// stringValue reads a flag of the parser's flag set by name, empty if the flag set doesn't have it.
+func (p *parser) stringValue(name string) string {
+	if f := p.fs.Lookup(name); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+func (p *parser) boolValue(name string) bool {
+	return p.stringValue(name) == "true"
+}
+
+// listValue reads a repeatable flag by name.
+func (p *parser) listValue(name string) []string {
+
+	f := p.fs.Lookup(name)
+	if f == nil {
+		return nil
+	}
+
+	if l, ok := f.Value.(*StringList); ok {
+		return l.Values()
+	}
+
+	if v := f.Value.String(); v != "" {
+		return strings.Split(v, ",")
+	}
+
+	return nil
+}
+
+// layerValue reads a flag that may come from the command line or from the files read so far, e.g. -config.flags.dir.
+func (p *parser) layerValue(fc *fileConfig, name string) string {
+
+	if !p.cliFlags[name] {
+		if v, ok := fc.values[name]; ok {
+			return v
+		}
+	}
+
+	return p.stringValue(name)
+}
+
+func (p *parser) envFlagName(s string) string {
+	return envName(p.stringValue(envPrefixFlag), s)
+}
+
+// envName is the environment variable for flag name: dots become underscores, behind prefix.
+func envName(prefix, name string) string {
+	return prefix + strings.ReplaceAll(name, ".", "_")
+}
+
+// NewLogger builds the exporter logger from the -log.format and -log.level values. Unlike SetLogger it reports values it does
+// not understand, and the level can be changed at runtime through the logging package, globally or per collector and target.
+func NewLogger(lf, ll *string) (*slog.Logger, error) {
+
+	promlogFormat := promslog.NewFormat()
+	if err := promlogFormat.Set(*lf); err != nil {
+		return nil, err
+	}
+
+	level, err := logging.ParseLevel(*ll)
+	if err != nil {
+		return nil, err
+	}
+
+	// promslog lets everything through, the logging handler does the filtering.
+	promlogLevel := promslog.NewLevel()
+	promlogLevel.Set("debug")
+
+	logging.SetLevel(level, 0)
+
+	base := promslog.New(&promslog.Config{Format: promlogFormat, Level: promlogLevel})
+
+	return slog.New(logging.NewHandler(base.Handler())), nil
 }
 
 func SetLogger(lf, ll *string) *promslog.Config {
@@ -112,29 +495,36 @@ func Usage(s string) {
 	fmt.Fprintf(f, "%s\n", s)
 	if hasHelpFlag(os.Args[1:]) {
 		flag.PrintDefaults()
+		collector.DefaultRegistry.WriteHelp(f)
 	} else {
 		fmt.Fprintf(f, `Run "%s -help" in order to see the description for all the available flags`+"\n", os.Args[0])
 	}
 }
 
+// WebConfig builds the exporter-toolkit web configuration from the -web.* flags. listenAddress is only used when no
+// -web.listen-address is given, so exporters can keep their own address flag as the default.
 func WebConfig(listenAddress *string) *web.FlagConfig {
+	return WebConfigFlagSet(flag.CommandLine, listenAddress)
+}
 
-	var webConfig web.FlagConfig
+// WebConfigFlagSet is WebConfig for the -web.* flags of fs. The values are read when it is called.
+func WebConfigFlagSet(fs *flag.FlagSet, listenAddress *string) *web.FlagConfig {
 
-	listenAddresses := []string{*listenAddress}
-	systemSocket := false
-	configFile := ""
+	p := &parser{fs: fs}
 
-	webConfig.WebListenAddresses = &listenAddresses
-	webConfig.WebSystemdSocket = &systemSocket
-	webConfig.WebConfigFile = &configFile
+	listenAddresses := p.listValue(webListenAddressFlag)
+	if len(listenAddresses) == 0 && listenAddress != nil && *listenAddress != "" {
+		listenAddresses = []string{*listenAddress}
+	}
 
-	return &webConfig
-}
+	systemdSocket := p.boolValue(webSystemdSocketFlag)
+	configFile := p.stringValue(webConfigFileFlag)
 
-func getEnvFlagName(s string) string {
-	s = strings.ReplaceAll(s, ".", "_")
-	return *prefix + s
+	return &web.FlagConfig{
+		WebListenAddresses: &listenAddresses,
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      &configFile,
+	}
 }
 
 func hasHelpFlag(args []string) bool {
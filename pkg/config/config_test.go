@@ -0,0 +1,365 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// newTestFlagSet is a flag set with the config flags and a couple of exporter flags.
+func newTestFlagSet() (*flag.FlagSet, *string, *string) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs)
+	server := fs.String("api.server", "", "")
+	user := fs.String("api.user", "nobody", "")
+	return fs, server, user
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestParseFileProblems(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		file    string
+		args    []string
+		env     map[string]string
+		problem string
+	}{
+		{"strict expansion", "api.server: ${NOPE_UNSET}:8443\n", []string{"-config.expand.env", "-config.expand.strict"}, nil, "NOPE_UNSET"},
+		{"unknown key", "api.srever: x\n", nil, nil, `unknown key "api.srever"`},
+		{"malformed value", "api.server: {a: b}\n", nil, nil, "flag api.server expects a plain value or a list"},
+		{"lenient expansion", "api.server: ${NOPE_UNSET}:8443\n", []string{"-config.expand.env"}, nil, ""},
+		{"expansion", "api.server: ${HOST}:8443\n", []string{"-config.expand.env", "-config.expand.strict"}, map[string]string{"HOST": "h"}, ""},
+		{"no expansion by default", "api.server: ${NOPE_UNSET}:8443\n", []string{"-config.expand.strict"}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			path := writeFile(t, t.TempDir(), "config.yml", tt.file)
+			fs, _, _ := newTestFlagSet()
+
+			err := ParseFlagSet(fs, append(tt.args, "-file", path), env(tt.env))
+
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var pe *ParseError
+			if !errors.As(err, &pe) || !strings.Contains(err.Error(), tt.problem) {
+				t.Fatalf("error = %v, want a ParseError mentioning %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestExpansionIsOptIn(t *testing.T) {
+
+	path := writeFile(t, t.TempDir(), "config.yml", "api.server: pa$${ss}${HOST}\n")
+
+	fs, server, _ := newTestFlagSet()
+	if err := ParseFlagSet(fs, []string{"-file", path}, env(map[string]string{"HOST": "h"})); err != nil {
+		t.Fatal(err)
+	}
+	if *server != "pa$${ss}${HOST}" {
+		t.Fatalf("api.server = %q, want it taken as it is without -config.expand.env", *server)
+	}
+
+	fs, server, _ = newTestFlagSet()
+	if err := ParseFlagSet(fs, []string{"-config.expand.env", "-file", path}, env(map[string]string{"HOST": "h"})); err != nil {
+		t.Fatal(err)
+	}
+	if *server != "pa${ss}h" {
+		t.Fatalf("api.server = %q with -config.expand.env, want pa${ss}h", *server)
+	}
+}
+
+func TestReloadRejectsFileProblems(t *testing.T) {
+
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yml", "api.server: good:8443\n")
+
+	fs, server, _ := newTestFlagSet()
+	if err := ParseFlagSet(fs, []string{"-config.expand.env", "-config.expand.strict", "-file", path}, env(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"api.server: ${NOPE_UNSET}:8443\n", "api.server: x\napi.srever: y\n"} {
+		writeFile(t, dir, "config.yml", content)
+		if err := ReloadFlagSet(fs); err == nil {
+			t.Errorf("reload of %q succeeded", content)
+		}
+		if *server != "good:8443" {
+			t.Errorf("api.server = %q after a failed reload", *server)
+		}
+	}
+}
+
+func TestParsePrecedence(t *testing.T) {
+
+	dir := t.TempDir()
+	first := writeFile(t, dir, "first.yml", "api.server: first\napi.user: first\n")
+	second := writeFile(t, dir, "second.yml", "api.server: second\n")
+	dotEnv := writeFile(t, dir, "test.env", "api_user=dotenv\napi_extra=dotenv\n")
+	flagsDir := filepath.Join(dir, "flags")
+	if err := os.Mkdir(flagsDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		dirs   map[string]string
+		server string
+		user   string
+		extra  string
+	}{
+		{"defaults", nil, nil, nil, "", "nobody", "unset"},
+		{"later file wins", []string{"-file", first, "-file", second}, nil, nil, "second", "first", "unset"},
+		{"command line wins", []string{"-file", first, "-api.server", "cli"}, nil, nil, "cli", "first", "unset"},
+		{"flags dir wins over files", []string{"-file", first, "-config.flags.dir", flagsDir}, nil, map[string]string{"api.server": "dir\n"}, "dir", "first", "unset"},
+		{"files win over environment", []string{"-envflag.enable", "-file", first}, map[string]string{"api_server": "env"}, nil, "first", "first", "unset"},
+		{"environment fills the rest", []string{"-envflag.enable", "-file", second}, map[string]string{"api_user": "env"}, nil, "second", "env", "unset"},
+		{"environment wins over .env", []string{"-envflag.enable", "-envflag.file", dotEnv}, map[string]string{"api_user": "env"}, nil, "", "env", "dotenv"},
+		{"env flags from a file", []string{"-file", writeFile(t, dir, "env.yml", "envflag.enable: true\nenvflag.prefix: X_\n")}, map[string]string{"X_api_server": "prefixed"}, nil, "prefixed", "nobody", "unset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			entries, _ := os.ReadDir(flagsDir)
+			for _, e := range entries {
+				os.Remove(filepath.Join(flagsDir, e.Name()))
+			}
+			for name, content := range tt.dirs {
+				writeFile(t, flagsDir, name, content)
+			}
+
+			fs, server, user := newTestFlagSet()
+			extra := fs.String("api.extra", "unset", "")
+
+			if err := ParseFlagSet(fs, tt.args, env(tt.env)); err != nil {
+				t.Fatal(err)
+			}
+
+			if *server != tt.server || *user != tt.user || *extra != tt.extra {
+				t.Errorf("got server=%q user=%q extra=%q, want %q %q %q", *server, *user, *extra, tt.server, tt.user, tt.extra)
+			}
+		})
+	}
+}
+
+func TestParseAppliesNothingOnError(t *testing.T) {
+
+	dir := t.TempDir()
+	modules := writeFile(t, dir, "modules.yml", "modules:\n  m:\n    bogus: true\n")
+
+	type section struct {
+		Name string `yaml:"name"`
+	}
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"bad expansion", "api.server: ${NOPE_UNSET}:8443\n"},
+		{"bad value", "api.server: set\napi.port: not-a-number\n"},
+		{"bad section", "api.server: set\nexample: {nmae: x}\n"},
+		{"bad modules file", "api.server: set\nconfig.modules: " + modules + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			fs, server, _ := newTestFlagSet()
+			fs.Int("api.port", 443, "")
+
+			sec := &section{Name: "default"}
+			RegisterSectionFlagSet(fs, "example", sec)
+
+			path := writeFile(t, dir, "config.yml", tt.file)
+			if err := ParseFlagSet(fs, []string{"-config.expand.env", "-config.expand.strict", "-file", path}, env(nil)); err == nil {
+				t.Fatal("parse succeeded")
+			}
+
+			if *server != "" || sec.Name != "default" || modulesOf(fs) != nil || LoadedFlagSet(fs) {
+				t.Errorf("partly applied: server=%q section=%q modules=%v", *server, sec.Name, modulesOf(fs))
+			}
+		})
+	}
+}
+
+func TestFlagSetsAreIndependent(t *testing.T) {
+
+	dir := t.TempDir()
+
+	one, _, _ := newTestFlagSet()
+	two, _, _ := newTestFlagSet()
+
+	RequireFlagSet(one, "api.server")
+
+	type section struct {
+		Name string `yaml:"name"`
+	}
+	sec := &section{}
+	RegisterSectionFlagSet(one, "example", sec)
+
+	modules := writeFile(t, dir, "modules.yml", "modules:\n  only_one: {}\n")
+
+	// two has neither the requirement nor the section, so the same file is wrong for it in a different way.
+	if err := ParseFlagSet(two, []string{"-file", writeFile(t, dir, "two.yml", "config.modules: "+modules+"\n")}, env(nil)); err != nil {
+		t.Fatalf("two: %v", err)
+	}
+	if err := ParseFlagSet(one, nil, env(nil)); err == nil || !strings.Contains(err.Error(), "required flag api.server") {
+		t.Fatalf("one: error = %v, want the required flag reported", err)
+	}
+
+	if err := ParseFlagSet(one, []string{"-api.server", "x", "-file", writeFile(t, dir, "one.yml", "example: {name: one}\n")}, env(nil)); err != nil {
+		t.Fatalf("one: %v", err)
+	}
+
+	if sec.Name != "one" {
+		t.Errorf("section = %q", sec.Name)
+	}
+
+	if m, _ := ModuleNamesFlagSet(two); len(m) != 1 || m[0] != "only_one" {
+		t.Errorf("two modules = %v", m)
+	}
+	if m, _ := ModuleNamesFlagSet(one); len(m) != 0 {
+		t.Errorf("one modules = %v, want none", m)
+	}
+
+	if WebConfigFlagSet(two, nil).WebListenAddresses == nil {
+		t.Error("no web config for two")
+	}
+}
+
+func TestReloadWaitsForHolders(t *testing.T) {
+
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yml", "api.server: a\n")
+
+	fs, server, _ := newTestFlagSet()
+	if err := ParseFlagSet(fs, []string{"-file", path}, env(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	release := HoldFlagSet(fs)
+
+	writeFile(t, dir, "config.yml", "api.server: b\n")
+
+	done := make(chan error)
+	go func() {
+		done <- ReloadFlagSet(fs)
+	}()
+
+	// Give the reload every chance to apply while the configuration is held.
+	for i := 0; i < 100; i++ {
+		if *server != "a" {
+			t.Fatalf("server = %q while held, want a", *server)
+		}
+		runtime.Gosched()
+	}
+
+	release()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	release = HoldFlagSet(fs)
+	defer release()
+
+	if *server != "b" {
+		t.Fatalf("server = %q after reload, want b", *server)
+	}
+}
+
+func TestIsSetAfterReload(t *testing.T) {
+
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yml", "api.server: a\n")
+
+	fs, _, _ := newTestFlagSet()
+	if err := ParseFlagSet(fs, []string{"-file", path}, env(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsSetFlagSet(fs, "api.server") || IsSetFlagSet(fs, "api.user") {
+		t.Fatal("IsSet does not follow the file")
+	}
+
+	writeFile(t, dir, "config.yml", "api.user: u\n")
+	if err := ReloadFlagSet(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if IsSetFlagSet(fs, "api.server") || !IsSetFlagSet(fs, "api.user") {
+		t.Fatal("IsSet does not follow the reloaded file")
+	}
+}
+
+func TestModuleChecks(t *testing.T) {
+
+	dir := t.TempDir()
+	path := writeFile(t, dir, "modules.yml", "modules:\n  good:\n    params: {port: \"443\"}\n")
+
+	fs, _, _ := newTestFlagSet()
+	if err := ParseFlagSet(fs, []string{"-" + modulesFlag, path}, env(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	portIsNumber := func(name string, m Module) error {
+		if port := m.Params["port"]; strings.Trim(port, "0123456789") != "" {
+			return errors.New("port is not a number")
+		}
+		return nil
+	}
+
+	if err := RegisterModuleCheckFlagSet(fs, portIsNumber); err != nil {
+		t.Fatalf("the loaded modules failed the check: %v", err)
+	}
+
+	writeFile(t, dir, "modules.yml", "modules:\n  bad:\n    params: {port: https}\n")
+
+	err := ReloadFlagSet(fs)
+	if err == nil || !strings.Contains(err.Error(), "module bad: port is not a number") {
+		t.Fatalf("ReloadFlagSet() = %v, want the module check to fail it", err)
+	}
+
+	if names, _ := ModuleNamesFlagSet(fs); len(names) != 1 || names[0] != "good" {
+		t.Fatalf("modules after the failed reload = %v, want the previous ones", names)
+	}
+
+	// A check registered after the bad modules got in reports them straight away.
+	fs2, _, _ := newTestFlagSet()
+	if err := ParseFlagSet(fs2, []string{"-" + modulesFlag, path}, env(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterModuleCheckFlagSet(fs2, portIsNumber); err == nil {
+		t.Fatal("RegisterModuleCheckFlagSet() accepted the loaded bad module")
+	}
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/secret"
+)
+
+// Sources of a flag value as reported by Effective. File values report the file and line instead.
+const (
+	SourceDefault     = "default"
+	SourceCommandLine = "command line"
+)
+
+// MarkSensitive has the value of a command line flag redacted wherever the effective configuration is shown. Flags holding a
+// secret.Secret are sensitive without being marked.
+func MarkSensitive(name string) {
+	MarkSensitiveFlagSet(flag.CommandLine, name)
+}
+
+// MarkSensitiveFlagSet is MarkSensitive for a flag of fs.
+func MarkSensitiveFlagSet(fs *flag.FlagSet, name string) {
+
+	st := stateOf(fs)
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	st.sensitive[name] = true
+}
+
+func isSensitive(fs *flag.FlagSet, f *flag.Flag) bool {
+
+	if _, ok := f.Value.(*secret.Secret); ok {
+		return true
+	}
+
+	st := stateOf(fs)
+
+	st.mtx.RLock()
+	defer st.mtx.RUnlock()
+
+	return st.sensitive[f.Name]
+}
+
+// IsSet tells whether a command line flag got its value from the command line, a configuration file or the environment
+// rather than its default. Unlike flag.Visit it knows about flags a reload took out of the file again.
+func IsSet(name string) bool {
+	return IsSetFlagSet(flag.CommandLine, name)
+}
+
+// IsSetFlagSet is IsSet for a flag set loaded with ParseFlagSet. Before that it only knows about the command line.
+func IsSetFlagSet(fs *flag.FlagSet, name string) bool {
+
+	p := loadedParser(fs)
+
+	if p == nil {
+		set := false
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == name {
+				set = true
+			}
+		})
+		return set
+	}
+
+	p.sourcesMtx.RLock()
+	defer p.sourcesMtx.RUnlock()
+
+	_, ok := p.sources[name]
+	return ok
+}
+
+// Setting is the effective value of a flag and where it came from.
+type Setting struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Source    string `json:"source"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+}
+
+// Effective returns every command line flag with its value and source, sorted by name.
+func Effective() []Setting {
+	return EffectiveFlagSet(flag.CommandLine)
+}
+
+// EffectiveFlagSet is Effective for a flag set loaded with ParseFlagSet. Sensitive values show as secret.Redacted, secrets given
+// as a file:, env: or exec: reference as that reference.
+func EffectiveFlagSet(fs *flag.FlagSet) []Setting {
+
+	p := loadedParser(fs)
+
+	settings := []Setting{}
+
+	fs.VisitAll(func(f *flag.Flag) {
+
+		s := Setting{Name: f.Name, Value: f.Value.String(), Source: SourceDefault}
+
+		if p != nil {
+			p.sourcesMtx.RLock()
+			if source, ok := p.sources[f.Name]; ok {
+				s.Source = source
+			}
+			p.sourcesMtx.RUnlock()
+		}
+
+		if isSensitive(fs, f) {
+			s.Sensitive = true
+			if sec, ok := f.Value.(*secret.Secret); ok && sec.Reference() != "" {
+				s.Value = sec.Reference()
+			} else if s.Value != "" {
+				s.Value = secret.Redacted
+			}
+		}
+
+		settings = append(settings, s)
+	})
+
+	return settings
+}
+
+// WriteEffective writes the effective configuration as YAML, flat keys with the source of each value as a comment, so the
+// output can be used as a -file configuration. Redacted values are written commented out, the flag left to be set again;
+// secrets given as a reference keep it. -config.check, -config.schema and -config.print are left out: they are what to do,
+// not configuration, and a file setting them would never get the exporter started.
+func WriteEffective(w io.Writer) error {
+	return writeSettings(w, Effective())
+}
+
+// WriteEffectiveJSON writes the effective configuration as a JSON list of settings.
+func WriteEffectiveJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(Effective())
+}
+
+func writeSettings(w io.Writer, settings []Setting) error {
+
+	for _, s := range settings {
+
+		switch s.Name {
+		case checkFlag, schemaFlag, printFlag, printFlagName:
+			continue
+		}
+
+		format := "%s: %q # %s\n"
+		if s.Sensitive && s.Value == secret.Redacted {
+			format = "# %s: %q # %s, redacted\n"
+		}
+
+		if _, err := fmt.Fprintf(w, format, s.Name, s.Value, s.Source); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/secret"
+)
+
+func TestEffectiveRedacts(t *testing.T) {
+
+	newFlagSet := func() (*flag.FlagSet, *secret.Secret, *secret.Secret, *string) {
+		fs, _, _ := newTestFlagSet()
+		var password, token secret.Secret
+		secret.FlagVar(fs, &password, "api.password", "")
+		secret.FlagVar(fs, &token, "api.token", "")
+		key := fs.String("api.key", "", "")
+		MarkSensitiveFlagSet(fs, "api.key")
+		return fs, &password, &token, key
+	}
+
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yml", "api.password: hunter2\napi.token: file:/run/secrets/token\napi.key: k3y\napi.server: s\n")
+
+	fs, _, _, _ := newFlagSet()
+	if err := ParseFlagSet(fs, []string{"--print-config", "-file", path}, env(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := map[string]Setting{}
+	for _, s := range EffectiveFlagSet(fs) {
+		settings[s.Name] = s
+	}
+
+	for name, want := range map[string]string{
+		"api.password": secret.Redacted,
+		"api.token":    "file:/run/secrets/token",
+		"api.key":      secret.Redacted,
+		"api.server":   "s",
+	} {
+		if got := settings[name].Value; got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if !settings["api.token"].Sensitive || settings["api.server"].Sensitive {
+		t.Error("sensitive flags not marked as such")
+	}
+
+	var buf bytes.Buffer
+	if err := writeSettings(&buf, EffectiveFlagSet(fs)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "hunter2") || strings.Contains(buf.String(), "k3y") {
+		t.Fatalf("secret values in the output:\n%s", buf.String())
+	}
+
+	if strings.Contains(buf.String(), "print") {
+		t.Fatalf("the output would print the configuration again:\n%s", buf.String())
+	}
+
+	// The output loads again as it is: redacted values left out, references kept.
+	printed := writeFile(t, dir, "printed.yml", buf.String())
+
+	again, password, token, key := newFlagSet()
+	if err := ParseFlagSet(again, []string{"-file", printed}, env(nil)); err != nil {
+		t.Fatalf("the printed configuration does not load: %v\n%s", err, buf.String())
+	}
+	if password.IsSet() || *key != "" {
+		t.Errorf("redacted values loaded: api.password set %t, api.key %q", password.IsSet(), *key)
+	}
+	if token.Reference() != "file:/run/secrets/token" {
+		t.Errorf("api.token = %q, want the reference kept", token.Reference())
+	}
+}
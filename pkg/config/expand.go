@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// expander replaces ${VAR} and ${VAR:-default} in configuration values with environment variables. $${ stands for a literal
+// ${. In strict mode a variable that is not set (and has no default) is an error, otherwise it expands to nothing.
+type expander struct {
+	enabled   bool
+	strict    bool
+	lookupEnv func(string) (string, bool)
+}
+
+// expander builds the expander for this parser from -config.expand.env, -config.expand.strict and the environment, including
+// the -envflag.file .env file if there is one.
+func (p *parser) expander() *expander {
+
+	x := &expander{
+		enabled:   p.boolValue(expandFlag),
+		strict:    p.boolValue(expandStrictFlag),
+		lookupEnv: p.lookupEnv,
+	}
+
+	if path := p.stringValue(envFileFlag); path != "" {
+		if dotEnv, err := readDotEnv(path); err == nil {
+			x.lookupEnv = func(name string) (string, bool) {
+				if v, ok := p.lookupEnv(name); ok {
+					return v, true
+				}
+				v, ok := dotEnv[name]
+				return v, ok
+			}
+		}
+	}
+
+	return x
+}
+
+// expandNode expands every scalar value below node in place. Mapping keys are left alone. It tells whether anything changed.
+func (x *expander) expandNode(path string, node *yaml.Node) (bool, []Problem) {
+
+	if x == nil || !x.enabled {
+		return false, nil
+	}
+
+	changed := false
+	problems := []Problem{}
+
+	var walk func(n *yaml.Node, isKey bool)
+	walk = func(n *yaml.Node, isKey bool) {
+
+		switch n.Kind {
+
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, c := range n.Content {
+				walk(c, false)
+			}
+
+		case yaml.MappingNode:
+			for i, c := range n.Content {
+				walk(c, i%2 == 0)
+			}
+
+		case yaml.ScalarNode:
+			if isKey || !strings.Contains(n.Value, "$") {
+				return
+			}
+
+			value, err := x.expand(n.Value)
+			if err != nil {
+				problems = append(problems, Problem{Source: path, Line: n.Line, Message: err.Error()})
+				return
+			}
+
+			if value != n.Value {
+				n.Value = value
+				// Let plain scalars be resolved again, so ${PORT} can end up an integer.
+				if n.Style == 0 {
+					n.Tag = ""
+				}
+				changed = true
+			}
+		}
+	}
+
+	walk(node, false)
+
+	return changed, problems
+}
+
+// expand does the substitution on a single value.
+func (x *expander) expand(s string) (string, error) {
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); {
+
+		switch {
+
+		case strings.HasPrefix(s[i:], "$${"):
+			b.WriteString("${")
+			i += 3
+
+		case strings.HasPrefix(s[i:], "${"):
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+
+			expr := s[i+2 : i+2+end]
+			name, def, hasDefault := strings.Cut(expr, ":-")
+
+			if !isEnvName(name) {
+				return "", fmt.Errorf("invalid variable name %q in %q", name, s)
+			}
+
+			value, ok := x.lookupEnv(name)
+			switch {
+			case hasDefault && value == "":
+				value = def
+			case !ok && x.strict:
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+
+			b.WriteString(value)
+			i += end + 3
+
+		default:
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+
+	return b.String(), nil
+}
+
+func isEnvName(name string) bool {
+
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,330 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// section is an exporter's own typed configuration struct, decoded from the -file configuration next to the flags.
+type section struct {
+	target   reflect.Value
+	defaults reflect.Value
+}
+
+// RegisterSection has the key name of the -file configuration decoded into target, which must be a pointer to the exporter's
+// own config struct. Dotted names address nested keys. Whatever target holds at registration serves as defaults for fields
+// missing from the file (a shallow copy, so keep defaults out of maps). Unknown fields are an error, and if target has a Validate() error method it is called before the
+// section is applied. Sections are decoded again on every reload.
+func RegisterSection(name string, target any) {
+	RegisterSectionFlagSet(flag.CommandLine, name, target)
+}
+
+// RegisterSectionFlagSet is RegisterSection for the configuration of fs.
+func RegisterSectionFlagSet(fs *flag.FlagSet, name string, target any) {
+
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		panic(fmt.Sprintf("config section %s: target must be a non-nil pointer", name))
+	}
+
+	defaults := reflect.New(v.Elem().Type()).Elem()
+	defaults.Set(v.Elem())
+
+	st := stateOf(fs)
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	st.sections[name] = &section{target: v, defaults: defaults}
+}
+
+// location is where a value came from: a file and line, or a file in the flags directory (line 0).
+type location struct {
+	path string
+	line int
+}
+
+func (l location) String() string {
+	if l.line == 0 {
+		return "flags directory file " + l.path
+	}
+	return fmt.Sprintf("file %s:%d", l.path, l.line)
+}
+
+// sectionNode is a section as found in a configuration file.
+type sectionNode struct {
+	node *yaml.Node
+	path string
+}
+
+// fileConfig is every configuration file layer merged and flattened to flag values, plus the raw nodes of registered sections.
+type fileConfig struct {
+	fs       *flag.FlagSet
+	expand   *expander
+	values   map[string]string
+	origins  map[string]location
+	sections map[string]sectionNode
+	problems []Problem
+}
+
+func newFileConfig(fs *flag.FlagSet, expand *expander) *fileConfig {
+	return &fileConfig{
+		fs:       fs,
+		expand:   expand,
+		values:   make(map[string]string),
+		origins:  make(map[string]location),
+		sections: make(map[string]sectionNode),
+	}
+}
+
+// readLayers merges the configuration file layers, lowest precedence first so later ones win: every -file in the order given
+// (a directory stands for its *.yml and *.yaml files in name order), then the -config.flags.dir directory.
+func (p *parser) readLayers() (*fileConfig, error) {
+
+	fc := newFileConfig(p.fs, p.expander())
+
+	for _, path := range p.listValue(fileFlag) {
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read file %s: %w", path, err)
+		}
+
+		if info.IsDir() {
+			err = fc.readConfDir(path)
+		} else {
+			err = fc.readFile(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dir := p.layerValue(fc, flagsDirFlag); dir != "" {
+		if err := fc.readFlagsDir(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return fc, nil
+}
+
+// readConfDir reads every *.yml and *.yaml file of a conf.d style directory in name order.
+func (fc *fileConfig) readConfDir(dir string) error {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("cannot read directory %s: %w", dir, err)
+	}
+
+	// os.ReadDir returns entries sorted by name already.
+	for _, entry := range entries {
+
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || (ext != ".yml" && ext != ".yaml") {
+			continue
+		}
+
+		if err := fc.readFile(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFlagsDir reads a directory with one file per flag: the file name is the flag name and the content its value, which is
+// how Kubernetes mounts a ConfigMap. Hidden files (such as the ..data links Kubernetes creates) and directories are skipped.
+func (fc *fileConfig) readFlagsDir(dir string) error {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("cannot read flags directory %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		if fc.fs.Lookup(name) == nil {
+			fc.problems = append(fc.problems, Problem{Source: path, Message: fmt.Sprintf("unknown flag %q", name)})
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot read file %s: %w", path, err)
+		}
+
+		fc.values[name] = strings.TrimRight(string(content), "\r\n")
+		fc.origins[name] = location{path: path}
+	}
+
+	return nil
+}
+
+// readFile reads a configuration file on top of what fc has so far. Nested mappings are flattened with dots, so
+// collector: {test: true} is the same as collector.test: true. Lists become comma separated values, and ${VAR} references
+// in values are expanded. Keys that match neither a
+// flag nor a section end up in problems.
+func (fc *fileConfig) readFile(path string) error {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read file %s: %w", path, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("cannot read contents of %s: %w", path, err)
+	}
+
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping of flag names to values", path, root.Line)
+	}
+
+	_, problems := fc.expand.expandNode(path, root)
+	fc.problems = append(fc.problems, problems...)
+
+	fc.flatten(path, "", root)
+
+	return nil
+}
+
+func (fc *fileConfig) flatten(path, prefix string, node *yaml.Node) {
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+
+		key, value := node.Content[i], node.Content[i+1]
+
+		name := key.Value
+		if prefix != "" {
+			name = prefix + "." + key.Value
+		}
+
+		if _, ok := stateOf(fc.fs).sectionNamed(name); ok {
+			fc.sections[name] = sectionNode{node: value, path: path}
+			continue
+		}
+
+		if fc.fs.Lookup(name) == nil {
+			if value.Kind == yaml.MappingNode {
+				fc.flatten(path, name, value)
+			} else {
+				fc.problems = append(fc.problems, Problem{Source: path, Line: key.Line, Message: fmt.Sprintf("unknown key %q", name)})
+			}
+			continue
+		}
+
+		switch value.Kind {
+
+		case yaml.ScalarNode:
+			fc.values[name] = value.Value
+
+		case yaml.SequenceNode:
+			items := make([]string, 0, len(value.Content))
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					fc.problems = append(fc.problems, Problem{Source: path, Line: item.Line, Message: fmt.Sprintf("flag %s expects a list of plain values", name)})
+					break
+				}
+				items = append(items, item.Value)
+			}
+			fc.values[name] = strings.Join(items, ",")
+
+		default:
+			fc.problems = append(fc.problems, Problem{Source: path, Line: value.Line, Message: fmt.Sprintf("flag %s expects a plain value or a list", name)})
+			continue
+		}
+
+		fc.origins[name] = location{path: path, line: value.Line}
+	}
+}
+
+// problem reports something wrong with the value of a flag where that value came from.
+func (fc *fileConfig) problem(name, message string) Problem {
+	origin := fc.origins[name]
+	return Problem{Source: origin.path, Line: origin.line, Message: message}
+}
+
+// decodeSections decodes every registered section into a fresh copy of its defaults, so nothing is touched until they are all
+// known to be good. Sections missing from the file get their defaults back.
+func (fc *fileConfig) decodeSections() (map[string]reflect.Value, []Problem) {
+
+	decoded := make(map[string]reflect.Value)
+	problems := []Problem{}
+
+	for name, s := range stateOf(fc.fs).allSections() {
+
+		fresh := reflect.New(s.defaults.Type())
+		fresh.Elem().Set(s.defaults)
+
+		sn, ok := fc.sections[name]
+		if !ok {
+			decoded[name] = fresh
+			continue
+		}
+
+		if err := decodeStrict(sn.node, fresh.Interface()); err != nil {
+			problems = append(problems, Problem{Source: sn.path, Line: sn.node.Line, Message: fmt.Sprintf("section %s: %s", name, err)})
+			continue
+		}
+
+		if v, ok := fresh.Interface().(interface{ Validate() error }); ok {
+			if err := v.Validate(); err != nil {
+				problems = append(problems, Problem{Source: sn.path, Line: sn.node.Line, Message: fmt.Sprintf("section %s: %s", name, err)})
+				continue
+			}
+		}
+
+		decoded[name] = fresh
+	}
+
+	return decoded, problems
+}
+
+// applySections copies decoded sections into the targets registered for fs.
+func applySections(fs *flag.FlagSet, decoded map[string]reflect.Value) {
+	st := stateOf(fs)
+	for name, value := range decoded {
+		if s, ok := st.sectionNamed(name); ok {
+			s.target.Elem().Set(value.Elem())
+		}
+	}
+}
+
+// decodeStrict decodes a node refusing unknown fields, which yaml.Node.Decode does not do on its own.
+func decodeStrict(node *yaml.Node, target any) error {
+
+	content, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	return decoder.Decode(target)
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"flag"
+	"strings"
+)
+
+// StringList is a flag.Value for flags that can be given more than once. Every occurrence adds to the list, and a comma
+// separated value (or a list in the -file configuration) adds several entries at once. The first value replaces the defaults.
+type StringList struct {
+	values []string
+	set    bool
+}
+
+// NewStringList returns a StringList holding defaults until it is first set.
+func NewStringList(defaults ...string) *StringList {
+	return &StringList{values: defaults}
+}
+
+// StringListVar defines a repeatable string flag on fs.
+func StringListVar(fs *flag.FlagSet, l *StringList, name, usage string) {
+	fs.Var(l, name, usage)
+}
+
+// Strings defines a repeatable string flag on the command line with the given defaults.
+func Strings(name string, defaults []string, usage string) *StringList {
+	l := NewStringList(defaults...)
+	StringListVar(flag.CommandLine, l, name, usage)
+	return l
+}
+
+func (l *StringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.values, ",")
+}
+
+func (l *StringList) Set(value string) error {
+
+	if !l.set {
+		l.values = nil
+		l.set = true
+	}
+
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l.values = append(l.values, v)
+		}
+	}
+
+	return nil
+}
+
+// Reset empties the list so the next Set starts over. Reload uses it before setting a new value.
+func (l *StringList) Reset() {
+	l.values = nil
+	l.set = false
+}
+
+func (l *StringList) Get() any {
+	return l.Values()
+}
+
+// Values returns a copy of the list.
+func (l *StringList) Values() []string {
+	return append([]string(nil), l.values...)
+}
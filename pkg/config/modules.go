@@ -0,0 +1,358 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultModule is used by /probe when no module parameter is given and the modules file defines it.
+const DefaultModule = "default"
+
+// Module is a named set of /probe settings in the style of blackbox_exporter modules.
+type Module struct {
+	API         collector.LoginConfig `yaml:"api"`
+	Collectors  []string              `yaml:"collectors"`
+	Params      map[string]string     `yaml:"params"`
+	Labels      map[string]string     `yaml:"labels"`
+	MinInterval time.Duration         `yaml:"min_interval"`
+}
+
+// AuthModule holds credentials for a group of targets, in the style of mysqld_exporter auth modules. It is picked with the
+// auth_module probe parameter or, failing that, by matching the target against Targets.
+type AuthModule struct {
+	Targets     []string              `yaml:"targets"`
+	Credentials collector.Credentials `yaml:",inline"`
+}
+
+type ModulesConfig struct {
+	Modules     map[string]Module     `yaml:"modules"`
+	AuthModules map[string]AuthModule `yaml:"auth_modules"`
+}
+
+// LoadModules reads and validates a modules file against the collectors of collector.DefaultRegistry. Unknown keys are an
+// error so typos don't go unnoticed. Values are taken as they are: only Parse, with -config.expand.env, expands ${VAR}.
+func LoadModules(path string) (*ModulesConfig, error) {
+	return loadModules(path, nil, collector.DefaultRegistry)
+}
+
+func loadModules(path string, x *expander, registry *collector.Registry) (*ModulesConfig, error) {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read modules file %s: %w", path, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("cannot parse modules file %s: %w", path, err)
+	}
+
+	changed, problems := x.expandNode(path, &doc)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid modules file: %s", problems[0])
+	}
+
+	mc := &ModulesConfig{}
+
+	// Decode the original content unless something was expanded, so error line numbers match the file.
+	if changed {
+		err = decodeStrict(&doc, mc)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(mc)
+	}
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("cannot parse modules file %s: %w", path, err)
+	}
+
+	if err := mc.ValidateRegistry(registry); err != nil {
+		return nil, fmt.Errorf("invalid modules file %s: %w", path, err)
+	}
+
+	return mc, nil
+}
+
+// Validate checks that every module only refers to collectors registered with collector.DefaultRegistry and has valid
+// label names.
+func (mc *ModulesConfig) Validate() error {
+	return mc.ValidateRegistry(collector.DefaultRegistry)
+}
+
+// ValidateRegistry is Validate for the collectors of registry.
+func (mc *ModulesConfig) ValidateRegistry(registry *collector.Registry) error {
+
+	for name, m := range mc.Modules {
+
+		for _, c := range m.Collectors {
+			if !registry.IsRegistered(c) {
+				return fmt.Errorf("module %s: unknown collector %q", name, c)
+			}
+		}
+
+		for label := range m.Labels {
+			if !model.LabelName(label).IsValidLegacy() {
+				return fmt.Errorf("module %s: invalid label name %q", name, label)
+			}
+		}
+
+		if m.MinInterval < 0 {
+			return fmt.Errorf("module %s: min_interval must not be negative", name)
+		}
+	}
+
+	for name, am := range mc.AuthModules {
+		for _, pattern := range am.Targets {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("auth module %s: invalid target pattern %q: %w", name, pattern, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RegisterModuleCheck has check vet every module whenever the modules file is loaded, at startup and on each reload, on top
+// of Validate. A module failing it fails the load, and a reload keeps the previous modules. /probe uses it to check module
+// params against the declared probe parameters. The modules loaded already are checked right away.
+func RegisterModuleCheck(check func(name string, m Module) error) error {
+	return RegisterModuleCheckFlagSet(flag.CommandLine, check)
+}
+
+// RegisterModuleCheckFlagSet is RegisterModuleCheck for the modules of fs.
+func RegisterModuleCheckFlagSet(fs *flag.FlagSet, check func(name string, m Module) error) error {
+
+	st := stateOf(fs)
+
+	st.mtx.Lock()
+	st.moduleChecks = append(st.moduleChecks, check)
+	st.mtx.Unlock()
+
+	if mc := modulesOf(fs); mc != nil {
+		return checkModule(mc, check)
+	}
+
+	return nil
+}
+
+// checkModules runs the registered module checks on mc.
+func (st *flagSetState) checkModules(mc *ModulesConfig) error {
+
+	st.mtx.RLock()
+	checks := st.moduleChecks
+	st.mtx.RUnlock()
+
+	var errs []error
+	for _, check := range checks {
+		if err := checkModule(mc, check); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkModule runs check on every module of mc in name order.
+func checkModule(mc *ModulesConfig, check func(name string, m Module) error) error {
+
+	names := make([]string, 0, len(mc.Modules))
+	for name := range mc.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := check(name, mc.Modules[name]); err != nil {
+			errs = append(errs, fmt.Errorf("module %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SetRegistry tells module loading whose collectors the modules of the command line configuration may list,
+// collector.DefaultRegistry unless set. Call it before Parse; exporter.Run does for Options.Registry.
+func SetRegistry(registry *collector.Registry) {
+	SetRegistryFlagSet(flag.CommandLine, registry)
+}
+
+// SetRegistryFlagSet is SetRegistry for the modules of fs.
+func SetRegistryFlagSet(fs *flag.FlagSet, registry *collector.Registry) {
+
+	st := stateOf(fs)
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	st.registry = registry
+}
+
+// collectorRegistry returns the registry modules of fs are checked against.
+func (st *flagSetState) collectorRegistry() *collector.Registry {
+
+	st.mtx.RLock()
+	defer st.mtx.RUnlock()
+
+	if st.registry == nil {
+		return collector.DefaultRegistry
+	}
+	return st.registry
+}
+
+// SetModules makes mc the modules configuration used by /probe.
+func SetModules(mc *ModulesConfig) {
+	SetModulesFlagSet(flag.CommandLine, mc)
+}
+
+// SetModulesFlagSet makes mc the modules configuration of fs.
+func SetModulesFlagSet(fs *flag.FlagSet, mc *ModulesConfig) {
+
+	st := stateOf(fs)
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	st.modules = mc
+}
+
+// modulesOf returns the modules configuration of fs, nil if there is none. A loaded configuration is never changed, reloads
+// replace it, so it can be read without holding the lock.
+func modulesOf(fs *flag.FlagSet) *ModulesConfig {
+
+	st := stateOf(fs)
+
+	st.mtx.RLock()
+	defer st.mtx.RUnlock()
+
+	return st.modules
+}
+
+// GetModule looks up a module by name. An empty name selects DefaultModule if it exists. A nil module without error means
+// no module applies and /probe should fall back to the global flags.
+func GetModule(name string) (*Module, string, error) {
+	return GetModuleFlagSet(flag.CommandLine, name)
+}
+
+// GetModuleFlagSet is GetModule for the modules of fs.
+func GetModuleFlagSet(fs *flag.FlagSet, name string) (*Module, string, error) {
+
+	loadedModules := modulesOf(fs)
+
+	if name == "" {
+		if loadedModules == nil {
+			return nil, "", nil
+		}
+		if m, ok := loadedModules.Modules[DefaultModule]; ok {
+			return &m, DefaultModule, nil
+		}
+		return nil, "", nil
+	}
+
+	if loadedModules == nil {
+		return nil, "", fmt.Errorf("unknown module %q: no modules file configured", name)
+	}
+
+	m, ok := loadedModules.Modules[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown module %q", name)
+	}
+
+	return &m, name, nil
+}
+
+// GetAuthModule picks the auth module for a probe. A non-empty name has to exist and, if it lists targets, match target: its
+// credentials are not handed to any other host. Otherwise the first auth module, in name order, with a pattern matching target
+// is used. A nil module without error means none applies.
+func GetAuthModule(name, target string) (*AuthModule, string, error) {
+	return GetAuthModuleFlagSet(flag.CommandLine, name, target)
+}
+
+// GetAuthModuleFlagSet is GetAuthModule for the modules of fs.
+func GetAuthModuleFlagSet(fs *flag.FlagSet, name, target string) (*AuthModule, string, error) {
+
+	loadedModules := modulesOf(fs)
+
+	if name != "" {
+		if loadedModules == nil {
+			return nil, "", fmt.Errorf("unknown auth module %q: no modules file configured", name)
+		}
+		am, ok := loadedModules.AuthModules[name]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown auth module %q", name)
+		}
+		if len(am.Targets) > 0 && !am.matches(target) {
+			return nil, "", fmt.Errorf("auth module %q does not apply to target %q", name, target)
+		}
+		return &am, name, nil
+	}
+
+	if loadedModules == nil {
+		return nil, "", nil
+	}
+
+	names := make([]string, 0, len(loadedModules.AuthModules))
+	for name := range loadedModules.AuthModules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		am := loadedModules.AuthModules[name]
+		if am.matches(target) {
+			return &am, name, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// matches tells whether one of the targets patterns of am matches target.
+func (am AuthModule) matches(target string) bool {
+	for _, pattern := range am.Targets {
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ModuleNames lists the loaded modules and auth modules, each sorted by name.
+func ModuleNames() (modules, authModules []string) {
+	return ModuleNamesFlagSet(flag.CommandLine)
+}
+
+// ModuleNamesFlagSet is ModuleNames for the modules of fs.
+func ModuleNamesFlagSet(fs *flag.FlagSet) (modules, authModules []string) {
+
+	loadedModules := modulesOf(fs)
+
+	modules, authModules = []string{}, []string{}
+
+	if loadedModules == nil {
+		return modules, authModules
+	}
+
+	for name := range loadedModules.Modules {
+		modules = append(modules, name)
+	}
+	for name := range loadedModules.AuthModules {
+		authModules = append(authModules, name)
+	}
+
+	sort.Strings(modules)
+	sort.Strings(authModules)
+
+	return modules, authModules
+}
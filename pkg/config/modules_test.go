@@ -0,0 +1,116 @@
+package config
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+)
+
+func TestModulesUseTheSetRegistry(t *testing.T) {
+
+	path := writeFile(t, t.TempDir(), "modules.yml", "modules:\n  mine:\n    collectors: [only_mine]\n")
+
+	registry := collector.NewRegistry()
+	registry.Register(collector.Registration{Name: "only_mine", Factory: func(*slog.Logger) (collector.Collector, error) {
+		return nil, nil
+	}})
+
+	fs, _, _ := newTestFlagSet()
+	err := ParseFlagSet(fs, []string{"-" + modulesFlag, path}, env(nil))
+	if err == nil || !strings.Contains(err.Error(), `unknown collector "only_mine"`) {
+		t.Fatalf("ParseFlagSet() = %v, want the default registry not to know the collector", err)
+	}
+
+	fs, _, _ = newTestFlagSet()
+	SetRegistryFlagSet(fs, registry)
+	if err := ParseFlagSet(fs, []string{"-" + modulesFlag, path}, env(nil)); err != nil {
+		t.Fatalf("ParseFlagSet() = %v with the registry set", err)
+	}
+
+	if err := ReloadFlagSet(fs); err != nil {
+		t.Fatalf("ReloadFlagSet() = %v with the registry set", err)
+	}
+}
+
+func TestModuleSelection(t *testing.T) {
+
+	fs, _, _ := newTestFlagSet()
+
+	// Without a modules file no module applies, and naming one is an error.
+	if m, name, err := GetModuleFlagSet(fs, ""); m != nil || name != "" || err != nil {
+		t.Fatalf("GetModule(\"\") = %v, %q, %v without modules", m, name, err)
+	}
+	if _, _, err := GetModuleFlagSet(fs, "fast"); err == nil {
+		t.Fatal("GetModule(\"fast\") did not fail without modules")
+	}
+
+	path := writeFile(t, t.TempDir(), "modules.yml", "modules:\n  default:\n    labels: {site: a}\n  fast:\n    labels: {site: b}\n")
+	if err := ParseFlagSet(fs, []string{"-" + modulesFlag, path}, env(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"", DefaultModule, false},
+		{"fast", "fast", false},
+		{"slow", "", true},
+	}
+
+	for _, tt := range tests {
+		m, name, err := GetModuleFlagSet(fs, tt.name)
+		if (err != nil) != tt.wantErr || name != tt.want || (m == nil) != (tt.want == "") {
+			t.Errorf("GetModule(%q) = %v, %q, %v, want %q", tt.name, m, name, err, tt.want)
+		}
+	}
+}
+
+func TestAuthModuleSelection(t *testing.T) {
+
+	path := writeFile(t, t.TempDir(), "modules.yml", `auth_modules:
+  anywhere:
+    username: anywhere
+  lab:
+    username: lab
+    targets: ["*.lab.example.com", "10.0.0.*"]
+  prod:
+    username: prod
+    targets: ["*.example.com"]
+`)
+
+	fs, _, _ := newTestFlagSet()
+	if err := ParseFlagSet(fs, []string{"-" + modulesFlag, path}, env(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, target string
+		want         string
+		wantErr      bool
+	}{
+		{"", "a.lab.example.com", "lab", false},
+		{"", "10.0.0.7", "lab", false},
+		{"", "web.example.com", "prod", false},
+		{"", "elsewhere.net", "", false},
+		{"prod", "web.example.com", "prod", false},
+		{"prod", "10.0.0.7", "", true},
+		{"lab", "web.example.com", "", true},
+		{"anywhere", "elsewhere.net", "anywhere", false},
+		{"missing", "web.example.com", "", true},
+	}
+
+	for _, tt := range tests {
+		am, name, err := GetAuthModuleFlagSet(fs, tt.name, tt.target)
+		if (err != nil) != tt.wantErr || name != tt.want {
+			t.Errorf("GetAuthModule(%q, %q) = %q, %v, want %q", tt.name, tt.target, name, err, tt.want)
+			continue
+		}
+		if tt.want != "" && am.Credentials.Username != tt.want {
+			t.Errorf("GetAuthModule(%q, %q) has the credentials of %q", tt.name, tt.target, am.Credentials.Username)
+		}
+	}
+}
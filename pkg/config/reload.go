@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+)
+
+// Reload reads the configuration file (flags and sections) and the modules file again and re-reads all secret references.
+// Everything is validated before anything is applied, so on error the running configuration stays as it was. Flags given on the
+// command line always keep their values. Flags that are no longer in the file fall back to their environment value (if
+// -envflag.enable is set) or default, flags no longer in the environment either to their default. The precedence is the
+// one of ParseFlagSet, worked out by the same code.
+func Reload() error {
+	return ReloadFlagSet(flag.CommandLine)
+}
+
+// ReloadFlagSet is Reload for a flag set loaded with ParseFlagSet. It checks the new configuration while everything keeps
+// running and then waits for the holders of HoldFlagSet to apply it.
+func ReloadFlagSet(fs *flag.FlagSet) error {
+
+	st := stateOf(fs)
+
+	st.reload.Lock()
+	defer st.reload.Unlock()
+
+	p := loadedParser(fs)
+	if p == nil {
+		return errors.New("cannot reload a flag set that has not been parsed")
+	}
+
+	lp, problems := p.plan()
+	if len(problems) > 0 {
+		return &ParseError{Problems: problems}
+	}
+
+	return p.apply(lp)
+}
+
+// Loaded tells whether the command line configuration has been parsed and loaded successfully.
+func Loaded() bool {
+	return LoadedFlagSet(flag.CommandLine)
+}
+
+// LoadedFlagSet is Loaded for a flag set loaded with ParseFlagSet.
+func LoadedFlagSet(fs *flag.FlagSet) bool {
+	return loadedParser(fs) != nil
+}
+
+// validateFlagValue checks value against a scratch copy of the flag, so the flag itself is left alone. Values that cannot be
+// copied this way are not checked.
+func validateFlagValue(f *flag.Flag, value string) error {
+
+	t := reflect.TypeOf(f.Value)
+	if t.Kind() != reflect.Pointer {
+		return nil
+	}
+
+	scratch, ok := reflect.New(t.Elem()).Interface().(flag.Value)
+	if !ok {
+		return nil
+	}
+
+	return scratch.Set(value)
+}
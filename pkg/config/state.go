@@ -0,0 +1,118 @@
+package config
+
+import (
+	"flag"
+	"sync"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+)
+
+// flagSetState is everything the config package keeps about one flag set: required and sensitive flags, registered sections,
+// the loaded modules, the registry they are checked against and, once it loaded successfully, the parser Reload works with.
+// Flag sets share none of it, so two exporters in one binary, each with a flag set of its own, don't step on each other.
+type flagSetState struct {
+	// values is held for reading while flags, sections and modules are read, and for writing while a load or reload
+	// applies new ones. reload keeps two reloads from running at once.
+	values sync.RWMutex
+	reload sync.Mutex
+
+	mtx       sync.RWMutex
+	required  map[string]bool
+	sensitive map[string]bool
+	sections  map[string]*section
+	modules   *ModulesConfig
+	parser    *parser
+	registry  *collector.Registry
+
+	moduleChecks []func(name string, m Module) error
+	checks       []func(value func(name string) string) error
+}
+
+var (
+	statesMtx = sync.Mutex{}
+	states    = make(map[*flag.FlagSet]*flagSetState)
+)
+
+// stateOf returns the state of fs, creating it on first use.
+func stateOf(fs *flag.FlagSet) *flagSetState {
+
+	statesMtx.Lock()
+	defer statesMtx.Unlock()
+
+	st, ok := states[fs]
+	if !ok {
+		st = &flagSetState{
+			required:  make(map[string]bool),
+			sensitive: make(map[string]bool),
+			sections:  make(map[string]*section),
+		}
+		states[fs] = st
+	}
+
+	return st
+}
+
+// loadedParser returns the parser of the last successful load of fs, nil if there was none.
+func loadedParser(fs *flag.FlagSet) *parser {
+
+	st := stateOf(fs)
+
+	st.mtx.RLock()
+	defer st.mtx.RUnlock()
+
+	return st.parser
+}
+
+// requiredNames returns the flags marked required for fs.
+func (st *flagSetState) requiredNames() []string {
+
+	st.mtx.RLock()
+	defer st.mtx.RUnlock()
+
+	names := make([]string, 0, len(st.required))
+	for name := range st.required {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// sectionNamed returns a registered section by name.
+func (st *flagSetState) sectionNamed(name string) (*section, bool) {
+
+	st.mtx.RLock()
+	defer st.mtx.RUnlock()
+
+	s, ok := st.sections[name]
+	return s, ok
+}
+
+// allSections returns a copy of the registered sections.
+func (st *flagSetState) allSections() map[string]*section {
+
+	st.mtx.RLock()
+	defer st.mtx.RUnlock()
+
+	sections := make(map[string]*section, len(st.sections))
+	for name, s := range st.sections {
+		sections[name] = s
+	}
+
+	return sections
+}
+
+// Hold keeps the command line configuration still until release is called: Reload waits for every holder to let go before
+// it changes flags, sections or modules, and holders never see a reload half done. Hold it while reading the configuration
+// and copy what you need, but let go before any I/O - a login, a collection, a slow client: a Reload waiting for the lock
+// keeps every new holder out, so one long hold stalls them all. Do not hold it twice in the same goroutine, a Reload
+// waiting in between would deadlock.
+func Hold() (release func()) {
+	return HoldFlagSet(flag.CommandLine)
+}
+
+// HoldFlagSet is Hold for the configuration of fs.
+func HoldFlagSet(fs *flag.FlagSet) (release func()) {
+	st := stateOf(fs)
+	st.values.RLock()
+	return st.values.RUnlock
+}
@@ -0,0 +1,147 @@
+package exporter
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	minInterval        = flag.Duration("probe.min.interval", 0, "Minimum time between two collections of the same /probe target. Probes arriving sooner get the cached result of the previous collection. Use 0 to disable.")
+	minIntervalTargets = intervalOverrides{}
+)
+
+func init() {
+	flag.Var(&minIntervalTargets, "probe.min.interval.targets", "Comma separated list of target=duration pairs overriding -probe.min.interval for single targets, e.g. \"slow.example.com=5m,fast.example.com=10s\".")
+}
+
+// cachedProbe is the result of the last collection for a probe key.
+type cachedProbe struct {
+	collected time.Time
+	interval  time.Duration
+	metrics   []prometheus.Metric
+}
+
+type probeCache struct {
+	mtx     sync.Mutex
+	entries map[string]*cachedProbe
+}
+
+var resultCache = &probeCache{entries: make(map[string]*cachedProbe)}
+
+// get returns the cached result for key if it is younger than interval.
+func (c *probeCache) get(key string, interval time.Duration) (*cachedProbe, bool) {
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.collected) >= interval {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// put stores a fresh result for key and drops every entry that has outlived its interval.
+func (c *probeCache) put(key string, interval time.Duration, metrics []prometheus.Metric) {
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+
+	for k, entry := range c.entries {
+		if now.Sub(entry.collected) >= entry.interval {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = &cachedProbe{collected: now, interval: interval, metrics: metrics}
+}
+
+// flush drops every cached result, e.g. after a configuration reload.
+func (c *probeCache) flush() {
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.entries = make(map[string]*cachedProbe)
+}
+
+// minIntervalFor returns the minimum collection interval for target. Per-target overrides win over the module interval,
+// which in turn wins over the global default.
+func minIntervalFor(target string, moduleInterval time.Duration) time.Duration {
+
+	if interval, ok := minIntervalTargets[target]; ok {
+		return interval
+	}
+
+	if moduleInterval > 0 {
+		return moduleInterval
+	}
+
+	return *minInterval
+}
+
+// intervalOverrides is the value of -probe.min.interval.targets. It is parsed as the flag is set, so a malformed list
+// fails the startup or the reload that brings it instead of every probe.
+type intervalOverrides map[string]time.Duration
+
+func (o *intervalOverrides) String() string {
+
+	if o == nil {
+		return ""
+	}
+
+	targets := make([]string, 0, len(*o))
+	for target := range *o {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	pairs := make([]string, 0, len(targets))
+	for _, target := range targets {
+		pairs = append(pairs, target+"="+(*o)[target].String())
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// Set replaces the overrides with the target=duration pairs of value.
+func (o *intervalOverrides) Set(value string) error {
+
+	overrides := make(intervalOverrides)
+
+	for _, pair := range strings.Split(value, ",") {
+
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, duration, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid entry %q, expected target=duration", pair)
+		}
+
+		interval, err := time.ParseDuration(duration)
+		if err != nil {
+			return fmt.Errorf("invalid interval for target %q: %w", name, err)
+		}
+		if interval < 0 {
+			return fmt.Errorf("invalid interval for target %q: must not be negative", name)
+		}
+
+		overrides[name] = interval
+	}
+
+	*o = overrides
+
+	return nil
+}
@@ -0,0 +1,300 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestIntervalOverrides(t *testing.T) {
+
+	tests := []struct {
+		value   string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{"", map[string]time.Duration{}, false},
+		{"a=5m", map[string]time.Duration{"a": 5 * time.Minute}, false},
+		{" a=5m , b.example.com=10s ,", map[string]time.Duration{"a": 5 * time.Minute, "b.example.com": 10 * time.Second}, false},
+		{"a", nil, true},
+		{"=5m", nil, true},
+		{"a=soon", nil, true},
+		{"a=-1s", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+
+			o := intervalOverrides{"old": time.Hour}
+
+			err := o.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, ok := o["old"]; !ok {
+					t.Fatal("a failed Set changed the overrides")
+				}
+				return
+			}
+
+			if len(o) != len(tt.want) {
+				t.Fatalf("Set(%q) = %v, want %v", tt.value, o, tt.want)
+			}
+			for target, interval := range tt.want {
+				if o[target] != interval {
+					t.Fatalf("Set(%q) = %v, want %v", tt.value, o, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMinIntervalFor(t *testing.T) {
+
+	defer func(global time.Duration, targets intervalOverrides) {
+		*minInterval, minIntervalTargets = global, targets
+	}(*minInterval, minIntervalTargets)
+
+	*minInterval = time.Minute
+	minIntervalTargets = intervalOverrides{"slow": 5 * time.Minute}
+
+	tests := []struct {
+		target string
+		module time.Duration
+		want   time.Duration
+	}{
+		{"other", 0, time.Minute},
+		{"other", 30 * time.Second, 30 * time.Second},
+		{"slow", 30 * time.Second, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := minIntervalFor(tt.target, tt.module); got != tt.want {
+			t.Errorf("minIntervalFor(%q, %s) = %s, want %s", tt.target, tt.module, got, tt.want)
+		}
+	}
+}
+
+func TestProbeCache(t *testing.T) {
+
+	c := &probeCache{entries: make(map[string]*cachedProbe)}
+
+	c.put("a", time.Hour, []prometheus.Metric{})
+	c.put("b", time.Nanosecond, []prometheus.Metric{})
+	time.Sleep(time.Millisecond)
+
+	if _, ok := c.get("a", time.Hour); !ok {
+		t.Fatal("fresh entry not served")
+	}
+	if _, ok := c.get("a", time.Nanosecond); ok {
+		t.Fatal("entry older than the interval asked for was served")
+	}
+	if _, ok := c.get("b", time.Nanosecond); ok {
+		t.Fatal("expired entry served")
+	}
+
+	// Putting drops what has expired.
+	c.put("c", time.Hour, nil)
+	if _, ok := c.entries["b"]; ok {
+		t.Fatal("expired entry kept")
+	}
+
+	c.flush()
+	if _, ok := c.get("a", time.Hour); ok {
+		t.Fatal("entry served after flush")
+	}
+}
+
+func TestCoalescer(t *testing.T) {
+
+	c := &coalescer{calls: make(map[string]*probeCall)}
+
+	var runs atomic.Int32
+	release := make(chan struct{})
+	collect := func() ([]prometheus.Metric, error) {
+		runs.Add(1)
+		<-release
+		return nil, errors.New("failed")
+	}
+
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	shared := make([]bool, 3)
+
+	for i := range shared {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i > 0 {
+				<-started
+			}
+			var err error
+			_, shared[i], err = c.do("key", time.Minute, func() ([]prometheus.Metric, error) {
+				close(started)
+				return collect()
+			})
+			if err == nil {
+				t.Error("the error of the shared collection was lost")
+			}
+		}()
+	}
+
+	<-started
+	// Give the others time to join before the collection finishes.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if runs.Load() != 1 {
+		t.Fatalf("collected %d times, want 1", runs.Load())
+	}
+	if shared[0] || !shared[1] || !shared[2] {
+		t.Fatalf("shared = %v, want only the followers to share", shared)
+	}
+
+	// Another key is collected on its own.
+	if _, sharedOther, _ := c.do("other", time.Minute, func() ([]prometheus.Metric, error) { return nil, nil }); sharedOther {
+		t.Fatal("a different key shared a collection")
+	}
+}
+
+type loginAPI struct {
+	fail   atomic.Bool
+	logins atomic.Int32
+}
+
+func (a *loginAPI) Login(target string, logger *slog.Logger) (map[string]any, error) {
+	a.logins.Add(1)
+	if a.fail.Load() {
+		return nil, errors.New("login refused")
+	}
+	return map[string]any{"target": target}, nil
+}
+
+func (a *loginAPI) Logout(map[string]any, *slog.Logger) error { return nil }
+
+func (a *loginAPI) Get(map[string]any, map[string]any, *slog.Logger) (any, error) { return nil, nil }
+
+func TestProbeCollectorSkipsFailedResults(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	api := &loginAPI{}
+	api.fail.Store(true)
+
+	registry := collector.NewRegistry()
+	registry.RegisterAPI(api)
+
+	cs, err := registry.NewProbeCollectorSet("test", "target", nil, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc := newProbeCollector(nil, "test", "cache-test", time.Hour, 0, 0, &cs)
+	defer resultCache.flush()
+
+	probe := func() {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			pc.Collect(ch)
+			close(ch)
+		}()
+		for range ch {
+		}
+	}
+
+	probe()
+	probe()
+	if api.logins.Load() != 2 {
+		t.Fatalf("logged in %d times, want a failed login not to be cached", api.logins.Load())
+	}
+
+	api.fail.Store(false)
+	probe()
+	probe()
+	if api.logins.Load() != 3 {
+		t.Fatalf("logged in %d times, want the successful result cached", api.logins.Load())
+	}
+}
+
+type scrapeFunc func(sc *collector.ScrapeContext) error
+
+func (f scrapeFunc) Scrape(sc *collector.ScrapeContext) error { return f(sc) }
+
+func TestProbeCollectorOutlivesTheLeader(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	registry := collector.NewRegistry()
+	registry.RegisterAPI(&loginAPI{})
+	registry.Register(collector.Registration{Name: "slow", Default: true, ScrapeFactory: func(*slog.Logger) (collector.ScrapeCollector, error) {
+		return scrapeFunc(func(sc *collector.ScrapeContext) error {
+			close(started)
+			select {
+			case <-release:
+				return nil
+			case <-sc.Context.Done():
+				return sc.Context.Err()
+			}
+		}), nil
+	}})
+
+	probe := func(ctx context.Context, timeout time.Duration) {
+		cs, err := registry.NewProbeCollectorSet("test", "target", nil, nil, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch := make(chan prometheus.Metric)
+		go func() {
+			newProbeCollector(ctx, "test", "leader-test", time.Hour, 0, timeout, &cs).Collect(ch)
+			close(ch)
+		}()
+		for range ch {
+		}
+	}
+	defer resultCache.flush()
+
+	// The leader goes away halfway through: the collection goes on, and what it got is kept for the others.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		probe(ctx, time.Minute)
+	}()
+
+	<-started
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	<-done
+
+	if _, ok := resultCache.get("leader-test", time.Hour); !ok {
+		t.Fatal("the collection failed with the request that started it")
+	}
+
+	// It is bounded by the timeout instead.
+	resultCache.flush()
+	started, release = make(chan struct{}), make(chan struct{})
+	defer close(release)
+
+	begin := time.Now()
+	probe(context.Background(), 20*time.Millisecond)
+	if time.Since(begin) > 5*time.Second {
+		t.Fatal("the collection ran past its timeout")
+	}
+	if _, ok := resultCache.get("leader-test", time.Hour); ok {
+		t.Fatal("a collection that timed out was kept")
+	}
+}
@@ -0,0 +1,75 @@
+package exporter
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	coalesceWindow = flag.Duration("probe.coalesce.window", 0, "Share a single collection between /probe requests for the same target and parameters arriving within this window. Use 0 to disable.")
+	probeTimeout   = flag.Duration("probe.timeout", 2*time.Minute, "How long a /probe collection shared with other requests or kept for -probe.min.interval may run, unless the request says with the X-Prometheus-Scrape-Timeout-Seconds header Prometheus sends.")
+)
+
+// scrapeTimeout returns the timeout Prometheus sent with r, 0 if there is none.
+func scrapeTimeout(r *http.Request) time.Duration {
+
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// probeCall is a single collection run shared by every request that joins it.
+type probeCall struct {
+	started time.Time
+	done    chan struct{}
+	metrics []prometheus.Metric
+	err     error
+}
+
+type coalescer struct {
+	mtx   sync.Mutex
+	calls map[string]*probeCall
+}
+
+var probeCoalescer = &coalescer{calls: make(map[string]*probeCall)}
+
+// do runs collect for key unless a run for the same key started less than window ago. In that case it waits for the running one
+// and returns its result instead. The second return value tells whether the result was shared.
+func (c *coalescer) do(key string, window time.Duration, collect func() ([]prometheus.Metric, error)) ([]prometheus.Metric, bool, error) {
+
+	c.mtx.Lock()
+	if call, ok := c.calls[key]; ok && time.Since(call.started) < window {
+		c.mtx.Unlock()
+		<-call.done
+		return call.metrics, true, call.err
+	}
+
+	call := &probeCall{started: time.Now(), done: make(chan struct{})}
+	c.calls[key] = call
+	c.mtx.Unlock()
+
+	defer func() {
+		close(call.done)
+
+		// Keep the call around until the window is over, so late arrivals still get to share it.
+		time.AfterFunc(window-time.Since(call.started), func() {
+			c.mtx.Lock()
+			if c.calls[key] == call {
+				delete(c.calls, key)
+			}
+			c.mtx.Unlock()
+		})
+	}()
+
+	call.metrics, call.err = collect()
+
+	return call.metrics, false, call.err
+}
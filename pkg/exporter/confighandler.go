@@ -0,0 +1,30 @@
+package exporter
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+)
+
+// ConfigHandler shows the effective configuration with the source of every value, sensitive values redacted. It answers in
+// YAML, or in JSON with ?format=json.
+func ConfigHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// Written out holding the configuration, sent after letting go so a slow client doesn't hold up a reload.
+		var buf bytes.Buffer
+
+		release := config.Hold()
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			config.WriteEffectiveJSON(&buf)
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			config.WriteEffective(&buf)
+		}
+		release()
+
+		w.Write(buf.Bytes())
+	})
+}
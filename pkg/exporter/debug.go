@@ -0,0 +1,115 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// teeHandler sends records to every handler that wants them.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := make(teeHandler, len(t))
+	for i, h := range t {
+		c[i] = h.WithAttrs(attrs)
+	}
+	return c
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	c := make(teeHandler, len(t))
+	for i, h := range t {
+		c[i] = h.WithGroup(name)
+	}
+	return c
+}
+
+// serveDebugProbe runs a single collection for /probe?debug=true and answers with everything logged during it, at debug level
+// whatever the configured one, followed by the metrics in text format. The collection gets collectors of its own and goes past
+// the result cache and coalescing. The exporter log still gets the usual lines at the usual level.
+func serveDebugProbe(w http.ResponseWriter, namespace, target string, h *eHandler, params collector.Params) {
+
+	var buf bytes.Buffer
+
+	capture := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	logger := slog.New(teeHandler{h.logger.Handler(), capture})
+
+	begin := time.Now()
+
+	logger.Info("beginning debug probe", "target", target)
+
+	var metrics bytes.Buffer
+
+	// Collectors are created holding the configuration, which is let go before the collection.
+	release := config.Hold()
+	cs, err := h.registry.NewDebugCollectorSet(namespace, target, h.module, params, logger)
+	release()
+
+	if err == nil {
+		if h.ctx != nil {
+			cs.SetContext(h.ctx)
+		}
+		registry := prometheus.NewRegistry()
+		if err = prometheus.WrapRegistererWith(h.labels, registry).Register(&cs); err == nil {
+			err = writeMetrics(&metrics, registry)
+		}
+	}
+
+	if err != nil {
+		logger.Error("debug probe failed", "target", target, "err", err)
+	} else {
+		logger.Info("debug probe finished", "target", target, "duration_seconds", time.Since(begin).Seconds())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	fmt.Fprintf(w, "Logs for the probe:\n%s\n\n", buf.String())
+	fmt.Fprintf(w, "Metrics that would have been returned:\n%s", metrics.String())
+}
+
+func writeMetrics(buf *bytes.Buffer, g prometheus.Gatherer) error {
+
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+
+	encoder := expfmt.NewEncoder(buf, expfmt.NewFormat(expfmt.TypeTextPlain))
+
+	for _, mf := range mfs {
+		if err := encoder.Encode(mf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,9 +1,11 @@
 package exporter
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
 	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
@@ -13,11 +15,20 @@ import (
 )
 
 type eHandler struct {
+	ctx                     context.Context
+	registry                *collector.Registry
 	eHandler                http.Handler
 	exporterMetricsRegistry *prometheus.Registry
 	includeExporterMetrics  bool
+	selfMetrics             bool
 	disableExporterTarget   bool
 	maxRequests             int
+	probeKey                string
+	minInterval             time.Duration
+	coalesceWindow          time.Duration
+	timeout                 time.Duration
+	module                  *collector.Module
+	labels                  map[string]string
 	logger                  *slog.Logger
 }
 
@@ -27,26 +38,47 @@ func (h *eHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (h *eHandler) New(namespace, target string, params map[string]string) (http.Handler, error) {
+func (h *eHandler) New(namespace, target string, params collector.Params) (http.Handler, error) {
 
 	if h.disableExporterTarget {
 		h.logger.Info("/metrics target is disabled, serving exporter metrics only")
-		return promhttp.Handler(), nil
+		return promhttp.InstrumentMetricHandler(
+			prometheus.DefaultRegisterer,
+			promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, exporterRegistry}, promhttp.HandlerOpts{}),
+		), nil
 	}
 
-	cl, err := collector.NewCollectorSet(namespace, target, params, h.logger)
+	cl, err := h.registry.NewProbeCollectorSet(namespace, target, h.module, params, h.logger)
 	if err != nil {
 		return nil, fmt.Errorf("could not create %s collector: %w", namespace, err)
 	}
 
+	if h.ctx != nil {
+		cl.SetContext(h.ctx)
+	}
+
 	registry := prometheus.NewRegistry()
 	registry.MustRegister(versioncollector.NewCollector(fmt.Sprintf("%s_exporter", namespace)))
-	if err := registry.Register(&cl); err != nil {
+
+	var c prometheus.Collector = &cl
+	if h.probeKey != "" {
+		c = newProbeCollector(h.ctx, namespace, h.probeKey, h.minInterval, h.coalesceWindow, h.timeout, &cl)
+	}
+
+	// Module labels go on every collected metric, but not on the exporter's own build info.
+	if err := prometheus.WrapRegistererWith(h.labels, registry).Register(c); err != nil {
 		return nil, fmt.Errorf("could not register %s collector: %w", namespace, err)
 	}
 
+	// The exporter's own counters - coalescing, cache, refused targets, reloads - are always on /metrics, the process and Go
+	// metrics only with includeExporterMetrics.
+	gatherers := prometheus.Gatherers{h.exporterMetricsRegistry, registry}
+	if h.selfMetrics {
+		gatherers = append(gatherers, exporterRegistry)
+	}
+
 	handler := promhttp.HandlerFor(
-		prometheus.Gatherers{h.exporterMetricsRegistry, registry},
+		gatherers,
 		promhttp.HandlerOpts{
 			ErrorLog:      slog.NewLogLogger(h.logger.Handler(), slog.LevelError),
 			ErrorHandling: promhttp.ContinueOnError,
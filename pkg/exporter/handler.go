@@ -4,14 +4,23 @@ import (
 	"fmt"
 	"log/slog"
 
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 func CreateHandler(includeExporerMetrics, disableExporterTarget bool, maxRequests int, namespace string, logger *slog.Logger) *eHandler {
+	return CreateHandlerWithRegistry(collector.DefaultRegistry, includeExporerMetrics, disableExporterTarget, maxRequests, namespace, logger)
+}
+
+// CreateHandlerWithRegistry is CreateHandler for the collectors and API of registry.
+func CreateHandlerWithRegistry(registry *collector.Registry, includeExporerMetrics, disableExporterTarget bool, maxRequests int, namespace string, logger *slog.Logger) *eHandler {
 	h := &eHandler{
+		registry:                registry,
 		exporterMetricsRegistry: prometheus.NewRegistry(),
 		includeExporterMetrics:  includeExporerMetrics,
+		selfMetrics:             true,
 		disableExporterTarget:   disableExporterTarget,
 		maxRequests:             maxRequests,
 		logger:                  logger,
@@ -24,7 +33,10 @@ func CreateHandler(includeExporerMetrics, disableExporterTarget bool, maxRequest
 		)
 	}
 
-	if handler, err := h.New(namespace, "", map[string]string{}); err != nil {
+	// Make sure the /probe metrics exist from the start, not only after the first probe.
+	getExporterMetrics(namespace)
+
+	if handler, err := h.New(namespace, "", collector.Params{}); err != nil {
 		panic(fmt.Sprintf("could not create metrics handler: %s", err))
 	} else {
 		h.eHandler = handler
@@ -1,30 +1,160 @@
 package exporter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+	"github.com/prezhdarov/prometheus-exporter/pkg/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 func CreateHandleFunc(w http.ResponseWriter, r *http.Request, namespace, extraParams string, logger *slog.Logger) {
+	CreateHandleFuncWithRegistry(collector.DefaultRegistry, w, r, namespace, extraParams, logger)
+}
 
-	p := r.URL.Query()
+// CreateHandleFuncWithRegistry is CreateHandleFunc for the collectors and API of registry.
+func CreateHandleFuncWithRegistry(registry *collector.Registry, w http.ResponseWriter, r *http.Request, namespace, extraParams string, logger *slog.Logger) {
 
-	target := p.Get("target")
+	parser, err := collector.NewParamParser(LegacyParams(strings.Split(extraParams, ",")...))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	serveProbe(registry, parser, w, r, namespace, logger)
+}
+
+// LegacyParams declares probe parameters the way a comma separated extraParams list always worked: optional strings, more
+// than one value (repeated or comma separated) reaching Update joined by commas.
+func LegacyParams(names ...string) []collector.ParamSpec {
+	specs := []collector.ParamSpec{}
+	for _, name := range names {
+		if name != "" {
+			specs = append(specs, collector.ParamSpec{Name: name, Multi: true})
+		}
+	}
+	return specs
+}
 
-	params := make(map[string]string)
+// ProbeHandler serves /probe for the collectors and API of registry, with parameters checked against specs. A request with
+// bad parameters gets a 400 listing every problem. Module params are checked against specs as the modules are loaded, from
+// now on and for the modules loaded already. Any error is about the specs or those modules.
+func ProbeHandler(registry *collector.Registry, namespace string, specs []collector.ParamSpec, logger *slog.Logger) (http.Handler, error) {
+
+	parser, err := collector.NewParamParser(specs)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, param := range strings.Split(extraParams, ",") {
-		params[param] = p.Get(param)
+	// Module params are checked as the modules are loaded: a bad one fails the start or the reload, not every probe.
+	err = config.RegisterModuleCheck(func(name string, m config.Module) error {
+		if problems := parser.CheckDefaults(m.Params); len(problems) > 0 {
+			return errors.New(strings.Join(problems, "; "))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveProbe(registry, parser, w, r, namespace, logger)
+	}), nil
+}
+
+func serveProbe(registry *collector.Registry, parser *collector.ParamParser, w http.ResponseWriter, r *http.Request, namespace string, logger *slog.Logger) {
+
+	p := r.URL.Query()
+
+	target := p.Get("target")
+
 	if target == "" {
 		http.Error(w, "target parameter is required", http.StatusBadRequest)
 		return
-	} else {
-		logger.Debug("scraping target", "target", target)
+	}
+
+	// The configuration is only held to read it, never while resolving, logging in or collecting: a reload waiting for it
+	// would keep every other request out until this probe is done.
+	release := config.Hold()
+	policy, err := currentTargetPolicy()
+	release()
+
+	// Only targets the allow and deny lists let through get a login with our credentials.
+	if err != nil {
+		logger.Error("invalid target rules", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if reason, err := policy.check(r.Context(), target); reason != "" {
+		getExporterMetrics(namespace).rejected.WithLabelValues(reason).Inc()
+		logger.Warn("probe target refused", "target", target, "reason", reason, "err", err)
+		http.Error(w, fmt.Sprintf("target %q is not allowed", target), http.StatusForbidden)
+		return
+	}
+
+	// The probe span continues the trace of an incoming traceparent header, if there is one.
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "probe", tracing.KindServer, tracing.String("target", target))
+	defer span.End()
+
+	debug := p.Get("debug") == "true"
+
+	release = config.Hold()
+	h, params, status, err := newProbeHandler(ctx, span, registry, parser, namespace, target, p, debug, scrapeTimeout(r), logger)
+	release()
+
+	if err != nil {
+		span.SetError(err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// A debug probe is always a fresh collection for this request alone.
+	if debug {
+		serveDebugProbe(w, namespace, target, h, params)
+		return
+	}
+
+	h.eHandler.ServeHTTP(w, r)
+}
+
+// newProbeHandler reads the modules, parameters and collectors a probe needs in one go, the caller holding the
+// configuration. Unless debug it creates the metrics handler too, a collection it shares running for timeout, or
+// -probe.timeout if that is 0. On error it returns the HTTP status to answer with.
+func newProbeHandler(ctx context.Context, span *tracing.Span, registry *collector.Registry, parser *collector.ParamParser, namespace, target string, p url.Values, debug bool, timeout time.Duration, logger *slog.Logger) (*eHandler, collector.Params, int, error) {
+
+	module, moduleName, err := config.GetModule(p.Get("module"))
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	authModule, authModuleName, err := config.GetAuthModule(p.Get("auth_module"), target)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	logger.Debug("scraping target", "target", target, "module", moduleName, "auth_module", authModuleName)
+
+	span.SetAttributes(tracing.String("module", moduleName), tracing.String("auth_module", authModuleName))
+
+	// Module parameters are defaults - anything given in the request wins.
+	var moduleParams map[string]string
+	if module != nil {
+		moduleParams = module.Params
+	}
+
+	params, problems := parser.Parse(p, moduleParams)
+	if len(problems) > 0 {
+		logger.Debug("invalid probe parameters", "target", target, "problems", strings.Join(problems, "; "))
+		return nil, nil, http.StatusBadRequest, errors.New("invalid probe parameters:\n" + strings.Join(problems, "\n"))
 	}
 
 	h := &eHandler{
@@ -32,16 +162,54 @@ func CreateHandleFunc(w http.ResponseWriter, r *http.Request, namespace, extraPa
 		includeExporterMetrics:  false,
 		disableExporterTarget:   false,
 		maxRequests:             20,
+		ctx:                     ctx,
+		registry:                registry,
 		logger:                  logger,
 	}
 
-	if handler, err := h.New(namespace, target, params); err != nil {
-		http.Error(w, "could not create metrics handler: "+err.Error(), http.StatusInternalServerError)
-		return
-	} else {
-		h.eHandler = handler
+	var moduleInterval time.Duration
 
+	if module != nil {
+		h.module = &collector.Module{
+			Name:       moduleName,
+			Collectors: module.Collectors,
+			Login:      module.API,
+		}
+		h.labels = module.Labels
+		moduleInterval = module.MinInterval
 	}
 
-	h.eHandler.ServeHTTP(w, r)
+	// Auth module credentials replace whatever the module (if any) had.
+	if authModule != nil {
+		if h.module == nil {
+			h.module = &collector.Module{}
+		}
+		h.module.Login.AuthModule = authModuleName
+		h.module.Login.Credentials = authModule.Credentials
+	}
+
+	if debug {
+		return h, params, 0, nil
+	}
+
+	interval := minIntervalFor(target, moduleInterval)
+
+	if *coalesceWindow > 0 || interval > 0 {
+		h.probeKey = probeKey(registry, target, moduleName, authModuleName, params)
+		h.minInterval = interval
+		h.coalesceWindow = *coalesceWindow
+		h.timeout = timeout
+		if h.timeout <= 0 {
+			h.timeout = *probeTimeout
+		}
+	}
+
+	handler, err := h.New(namespace, target, params)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.New("could not create metrics handler: " + err.Error())
+	}
+
+	h.eHandler = handler
+
+	return h, params, 0, nil
 }
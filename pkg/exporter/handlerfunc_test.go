@@ -0,0 +1,158 @@
+package exporter
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var loadOnce sync.Once
+
+// loadCommandLine loads the command line configuration once for the tests that reload it. Nothing is set, every flag keeps
+// its default.
+func loadCommandLine(t *testing.T) {
+	t.Helper()
+
+	var err error
+	loadOnce.Do(func() {
+		err = config.ParseFlagSet(flag.CommandLine, []string{}, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reloadWithin reloads the command line configuration and fails the test if that takes longer than timeout.
+func reloadWithin(t *testing.T, timeout time.Duration) {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() { done <- config.Reload() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(timeout):
+		t.Fatal("reload waited for a request stuck talking to the target")
+	}
+}
+
+func TestProbeDoesNotHoldConfiguration(t *testing.T) {
+
+	loadCommandLine(t)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	api := &blockingAPI{release: make(chan struct{})}
+	registry := collector.NewRegistry()
+	registry.RegisterAPI(api)
+
+	handler, err := ProbeHandler(registry, "test", nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/probe?target=stuck.example.com", nil))
+	}()
+
+	for api.logins.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	reloadWithin(t, 5*time.Second)
+
+	// Nor are other requests kept waiting.
+	rec := httptest.NewRecorder()
+	ConfigHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/config answered %d while a probe was running", rec.Code)
+	}
+
+	close(api.release)
+	<-done
+}
+
+func TestDebugProbe(t *testing.T) {
+
+	loadCommandLine(t)
+
+	defer func(interval time.Duration) { *minInterval = interval }(*minInterval)
+	*minInterval = time.Hour
+	defer resultCache.flush()
+
+	// The exporter log only takes errors: the debug lines are for the response alone.
+	var exporterLog bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&exporterLog, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	desc := prometheus.NewDesc("test_widgets", "Widgets seen.", nil, nil)
+
+	api := &loginAPI{}
+	registry := collector.NewRegistry()
+	registry.RegisterAPI(api)
+	registry.Register(collector.Registration{Name: "widgets", Default: true, ScrapeFactory: func(*slog.Logger) (collector.ScrapeCollector, error) {
+		return scrapeFunc(func(sc *collector.ScrapeContext) error {
+			sc.Logger.Debug("counting widgets", "target", sc.Target)
+			sc.Gauge(desc, 7)
+			return nil
+		}), nil
+	}})
+
+	handler, err := ProbeHandler(registry, "test", nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?target=debug.example.com&debug=true", nil))
+
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("debug probe answered %d: %s", rec.Code, body)
+		}
+
+		logs, metrics, ok := strings.Cut(body, "Metrics that would have been returned:")
+		if !ok || !strings.HasPrefix(logs, "Logs for the probe:") {
+			t.Fatalf("unexpected debug probe answer:\n%s", body)
+		}
+		if !strings.Contains(logs, "counting widgets") || !strings.Contains(logs, "debug probe finished") {
+			t.Errorf("the probe's debug lines are missing:\n%s", logs)
+		}
+		if !strings.Contains(metrics, "test_widgets 7") || !strings.Contains(metrics, `test_scrape_collector_success{collector="widgets"} 1`) {
+			t.Errorf("the metrics are missing:\n%s", metrics)
+		}
+
+		// Every debug probe is a collection of its own, never a cached one.
+		if int(api.logins.Load()) != i {
+			t.Fatalf("logged in %d times after %d debug probes", api.logins.Load(), i)
+		}
+	}
+
+	if strings.Contains(exporterLog.String(), "counting widgets") {
+		t.Error("the debug lines went to the exporter log at its error level")
+	}
+
+	// A bad request fails like any probe.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?target=debug.example.com&debug=true&module=nope", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("debug probe of an unknown module answered %d", rec.Code)
+	}
+}
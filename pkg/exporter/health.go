@@ -0,0 +1,188 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+)
+
+var (
+	loginCheckInterval = flag.Duration("health.login.interval", 0, "How often to log in to the default target (-api.server or whatever the API uses) in the background for /-/ready. Use 0 to leave the upstream out of readiness.")
+	loginCheckTimeout  = flag.Duration("health.login.timeout", 10*time.Second, "How long a background login check may take before it counts as failed.")
+)
+
+// loginCheck keeps the outcome of the last background login, so readiness probes never reach the upstream themselves.
+type loginCheck struct {
+	interval time.Duration
+
+	mtx     sync.Mutex
+	checked time.Time
+	err     error
+
+	// running is set while a login is in flight, which may be well past its timeout.
+	running atomic.Bool
+}
+
+var (
+	loginChecksMtx = sync.Mutex{}
+	loginChecks    = make(map[*collector.Registry]*loginCheck)
+)
+
+// loginCheckFor returns the login check WatchLogin runs for registry, nil if there is none.
+func loginCheckFor(registry *collector.Registry) *loginCheck {
+	loginChecksMtx.Lock()
+	defer loginChecksMtx.Unlock()
+	return loginChecks[registry]
+}
+
+func (c *loginCheck) run(registry *collector.Registry, logger *slog.Logger) {
+
+	// A login that hangs is not waited for past the timeout, but not piled onto either.
+	if !c.running.CompareAndSwap(false, true) {
+		logger.Warn("background login check skipped, the previous one is still running")
+		return
+	}
+
+	release := config.Hold()
+	timeout := *loginCheckTimeout
+	release()
+
+	done := make(chan error, 1)
+	// The login runs without holding the configuration: one that hangs would keep every reload, and with it every request,
+	// waiting. Whatever the API reads from the configuration it reads holding it briefly itself.
+	go func() {
+		defer c.running.Store(false)
+		done <- registry.CheckLogin(logger)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		err = fmt.Errorf("login did not finish within %s", timeout)
+	}
+
+	if err != nil {
+		logger.Warn("background login check failed", "err", err)
+	}
+
+	c.mtx.Lock()
+	c.checked = time.Now()
+	c.err = err
+	c.mtx.Unlock()
+}
+
+func (c *loginCheck) status() error {
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	switch {
+	case c.checked.IsZero():
+		return errors.New("no login check finished yet")
+	case c.err != nil:
+		return c.err
+	case time.Since(c.checked) > 2*c.interval+*loginCheckTimeout:
+		return fmt.Errorf("last successful login check was %s ago", time.Since(c.checked).Round(time.Second))
+	}
+
+	return nil
+}
+
+// HealthyHandler answers 200 as long as the process serves HTTP, meant for /-/healthy and liveness probes.
+func HealthyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Healthy\n"))
+	})
+}
+
+// WatchLogin logs in to the default target of registry every -health.login.interval in the background until ctx is done,
+// for ReadyHandler to report on. It does nothing with the interval at 0.
+func WatchLogin(ctx context.Context, registry *collector.Registry, logger *slog.Logger) {
+
+	interval := *loginCheckInterval
+	if interval <= 0 {
+		return
+	}
+
+	check := &loginCheck{interval: interval}
+
+	loginChecksMtx.Lock()
+	loginChecks[registry] = check
+	loginChecksMtx.Unlock()
+
+	go func() {
+
+		defer func() {
+			loginChecksMtx.Lock()
+			if loginChecks[registry] == check {
+				delete(loginChecks, registry)
+			}
+			loginChecksMtx.Unlock()
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			check.run(registry, logger)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// ReadyHandler answers 200 once the configuration is loaded, every enabled collector was created and, while WatchLogin
+// runs, the last background login succeeded, and 503 listing what is wrong otherwise. Meant for /-/ready and readiness
+// probes. Create the collectors with collector.InitCollectors first, or they only count once the first scrape made them.
+func ReadyHandler(logger *slog.Logger) http.Handler {
+	return ReadyHandlerWithRegistry(collector.DefaultRegistry, logger)
+}
+
+// ReadyHandlerWithRegistry is ReadyHandler for the collectors and API of registry.
+func ReadyHandlerWithRegistry(registry *collector.Registry, logger *slog.Logger) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var problems []string
+
+		release := config.Hold()
+
+		if !config.Loaded() {
+			problems = append(problems, "config: not loaded")
+		}
+
+		if err := registry.Ready(); err != nil {
+			problems = append(problems, "collectors: "+strings.ReplaceAll(err.Error(), "\n", "; "))
+		}
+
+		if check := loginCheckFor(registry); check != nil {
+			if err := check.status(); err != nil {
+				problems = append(problems, "login: "+err.Error())
+			}
+		}
+
+		release()
+
+		if len(problems) > 0 {
+			http.Error(w, "Not ready\n"+strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("Ready\n"))
+	})
+}
@@ -0,0 +1,132 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+)
+
+// blockingAPI logs in once release is closed.
+type blockingAPI struct {
+	loginAPI
+	release chan struct{}
+}
+
+func (a *blockingAPI) Login(target string, logger *slog.Logger) (map[string]any, error) {
+	data, err := a.loginAPI.Login(target, logger)
+	<-a.release
+	return data, err
+}
+
+func TestLoginCheckSkipsWhileRunning(t *testing.T) {
+
+	defer func(timeout time.Duration) { *loginCheckTimeout = timeout }(*loginCheckTimeout)
+	*loginCheckTimeout = 10 * time.Millisecond
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	api := &blockingAPI{release: make(chan struct{})}
+	registry := collector.NewRegistry()
+	registry.RegisterAPI(api)
+
+	check := &loginCheck{interval: time.Minute}
+
+	check.run(registry, logger)
+	if check.status() == nil {
+		t.Fatal("a login past its timeout counts as ready")
+	}
+
+	check.run(registry, logger)
+	if api.logins.Load() != 1 {
+		t.Fatalf("logged in %d times while the first login hung, want 1", api.logins.Load())
+	}
+
+	close(api.release)
+	for check.running.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	check.run(registry, logger)
+	if api.logins.Load() != 2 {
+		t.Fatalf("logged in %d times after the first login finished, want 2", api.logins.Load())
+	}
+	if err := check.status(); err != nil {
+		t.Fatalf("status() = %v after a successful login", err)
+	}
+}
+
+func TestLoginCheckDoesNotHoldConfiguration(t *testing.T) {
+
+	loadCommandLine(t)
+
+	defer func(timeout time.Duration) { *loginCheckTimeout = timeout }(*loginCheckTimeout)
+	*loginCheckTimeout = 10 * time.Millisecond
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	api := &blockingAPI{release: make(chan struct{})}
+	registry := collector.NewRegistry()
+	registry.RegisterAPI(api)
+
+	check := &loginCheck{interval: time.Minute}
+	check.run(registry, logger)
+
+	// The login hangs past its timeout, a reload still goes through.
+	reloadWithin(t, 5*time.Second)
+
+	close(api.release)
+	for check.running.Load() {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReadyHandlerHasNoSideEffects(t *testing.T) {
+
+	loadCommandLine(t)
+
+	defer func(interval time.Duration) { *loginCheckInterval = interval }(*loginCheckInterval)
+	*loginCheckInterval = time.Hour
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var created atomic.Int32
+
+	api := &loginAPI{}
+	registry := collector.NewRegistry()
+	registry.RegisterAPI(api)
+	registry.Register(collector.Registration{Name: "c", Default: true, Factory: func(*slog.Logger) (collector.Collector, error) {
+		created.Add(1)
+		return nil, nil
+	}})
+
+	handler := ReadyHandlerWithRegistry(registry, logger)
+
+	if created.Load() != 0 || api.logins.Load() != 0 {
+		t.Fatal("creating the handler created collectors or logged in")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	WatchLogin(ctx, registry, logger)
+
+	for loginCheckFor(registry).status() != nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ready answered %d %q, want 200", rec.Code, rec.Body.String())
+	}
+
+	cancel()
+	for loginCheckFor(registry) != nil {
+		time.Sleep(time.Millisecond)
+	}
+}
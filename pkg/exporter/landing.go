@@ -0,0 +1,117 @@
+package exporter
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// LandingPage is what the landing page says about an exporter. Collectors, modules and version come from the framework.
+type LandingPage struct {
+	Name        string                // Exporter name, usually <namespace>_exporter.
+	Description string                // One line about what it exports.
+	Links       []web.LandingLinks    // Routes worth a link, /metrics, /probe and the like.
+	ProbeParams []string              // Extra /probe parameters, added to the probe form.
+	Params      []collector.ParamSpec // Declared /probe parameters, added to the probe form after ProbeParams.
+	Registry    *collector.Registry   // Whose collectors to list, collector.DefaultRegistry if nil.
+}
+
+var landingStatus = template.Must(template.New("status").Parse(`
+<h3>Collectors</h3>
+{{- range $tag := .Tags }}
+<h4>{{ $tag }}</h4>
+<table>
+{{- range index $.Collectors $tag }}
+<tr><td>{{ .Name }}</td><td>{{ if .Enabled }}enabled{{ else }}disabled{{ end }}</td><td>{{ .Description }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+<h3>Modules</h3>
+{{ if .Modules }}<p>{{ range $i, $m := .Modules }}{{ if $i }}, {{ end }}{{ $m }}{{ end }}</p>{{ else }}<p>No modules loaded.</p>{{ end }}
+{{ if .AuthModules }}<h3>Auth modules</h3>
+<p>{{ range $i, $m := .AuthModules }}{{ if $i }}, {{ end }}{{ $m }}{{ end }}</p>{{ end }}
+`))
+
+// LandingPageHandler serves the landing page: the links, a /probe form, the registered collectors with their state, the
+// loaded modules and the version. It is rendered on every request so reloaded modules show up.
+func LandingPageHandler(lp LandingPage, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		registry := lp.Registry
+		if registry == nil {
+			registry = collector.DefaultRegistry
+		}
+
+		release := config.Hold()
+		modules, authModules := config.ModuleNames()
+		tags, collectors := registry.CollectorsByTag()
+		release()
+
+		var status bytes.Buffer
+		if err := landingStatus.Execute(&status, map[string]any{
+			"Tags":        tags,
+			"Collectors":  collectors,
+			"Modules":     modules,
+			"AuthModules": authModules,
+		}); err != nil {
+			logger.Error("cannot render landing page", "err", err)
+			http.Error(w, "cannot render landing page", http.StatusInternalServerError)
+			return
+		}
+
+		inputs := []web.LandingFormInput{
+			{Label: "Target", Type: "text", Name: "target", Placeholder: "host:port"},
+			{Label: "Module", Type: "text", Name: "module", Placeholder: config.DefaultModule},
+			{Label: "Auth module", Type: "text", Name: "auth_module", Placeholder: "matched by target"},
+		}
+		for _, param := range lp.ProbeParams {
+			inputs = append(inputs, web.LandingFormInput{Label: param, Type: "text", Name: param})
+		}
+		for _, spec := range lp.Params {
+			inputs = append(inputs, web.LandingFormInput{Label: spec.Name, Type: "text", Name: spec.Name, Placeholder: paramPlaceholder(spec)})
+		}
+		inputs = append(inputs, web.LandingFormInput{Label: "Debug", Type: "checkbox", Name: "debug", Value: "true"})
+
+		links := make([]web.LandingLinks, len(lp.Links))
+		copy(links, lp.Links)
+
+		page, err := web.NewLandingPage(web.LandingConfig{
+			Name:        lp.Name,
+			Description: lp.Description,
+			Version:     version.Info(),
+			Links:       links,
+			Form:        web.LandingForm{Action: "/probe", Inputs: inputs},
+			ExtraHTML:   status.String(),
+			Profiling:   "false",
+		})
+		if err != nil {
+			logger.Error("cannot render landing page", "err", err)
+			http.Error(w, "cannot render landing page", http.StatusInternalServerError)
+			return
+		}
+
+		page.ServeHTTP(w, r)
+	})
+}
+
+// paramPlaceholder hints at what a declared parameter takes.
+func paramPlaceholder(spec collector.ParamSpec) string {
+	switch {
+	case spec.Default != "":
+		return spec.Default
+	case len(spec.Allowed) > 0:
+		return strings.Join(spec.Allowed, " | ")
+	case spec.Description != "":
+		return spec.Description
+	case spec.Type != "":
+		return string(spec.Type)
+	}
+	return ""
+}
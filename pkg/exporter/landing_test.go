@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+func TestLandingPage(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	registry := collector.NewRegistry()
+	noop := func(*slog.Logger) (collector.Collector, error) { return nil, nil }
+	registry.Register(collector.Registration{Name: "vms", Description: "Virtual machines <and> templates", Default: true, Tags: []string{"compute"}, Factory: noop})
+	registry.Register(collector.Registration{Name: "disks", Description: "Disk usage", Tags: []string{"storage", "compute"}, Factory: noop})
+	registry.Register(collector.Registration{Name: "misc", Default: true, Factory: noop})
+
+	handler := LandingPageHandler(LandingPage{
+		Name:        "test_exporter",
+		Description: "Exports test things",
+		Links:       []web.LandingLinks{{Address: "/metrics", Text: "Metrics"}, {Address: "/extra", Text: "Extra route"}},
+		ProbeParams: []string{"site"},
+		Params:      []collector.ParamSpec{{Name: "detail", Allowed: []string{"low", "high"}}},
+		Registry:    registry,
+	}, logger)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("landing page answered %d", rec.Code)
+	}
+
+	page := rec.Body.String()
+
+	for _, want := range []string{
+		"test_exporter",
+		"Exports test things",
+		`href="/extra"`,
+		`action="/probe"`,
+		`name="target"`,
+		`name="auth_module"`,
+		`name="site"`,
+		`name="detail"`,
+		`placeholder="low | high"`,
+		`name="debug"`,
+		"<h4>compute</h4>",
+		"<h4>storage</h4>",
+		"<h4>" + collector.Untagged + "</h4>",
+		"<tr><td>vms</td><td>enabled</td><td>Virtual machines &lt;and&gt; templates</td></tr>",
+		"<tr><td>disks</td><td>disabled</td><td>Disk usage</td></tr>",
+		"<tr><td>misc</td><td>enabled</td>",
+		"No modules loaded.",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("landing page lacks %q", want)
+		}
+	}
+
+	// A collector with several tags is listed under each.
+	if n := strings.Count(page, "<tr><td>disks</td>"); n != 2 {
+		t.Errorf("disks listed %d times, want once per tag", n)
+	}
+}
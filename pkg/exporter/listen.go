@@ -0,0 +1,107 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// ListenAndServe is web.ListenAndServe with unix socket support: listen addresses written as unix:///path/to/socket (or
+// unix:/path) get a unix socket listener. TLS and authentication from the web config file apply to every listener.
+func ListenAndServe(server *http.Server, flags *web.FlagConfig, logger *slog.Logger) error {
+
+	if (flags.WebSystemdSocket != nil && *flags.WebSystemdSocket) || flags.WebListenAddresses == nil || !hasUnixAddress(*flags.WebListenAddresses) {
+		return web.ListenAndServe(server, flags, logger)
+	}
+
+	listeners := make([]net.Listener, 0, len(*flags.WebListenAddresses))
+
+	for _, address := range *flags.WebListenAddresses {
+
+		var listener net.Listener
+		var err error
+
+		switch {
+
+		case isUnixAddress(address):
+
+			path := unixSocketPath(address)
+
+			if err := removeStaleSocket(path); err != nil {
+				return err
+			}
+
+			listener, err = net.Listen("unix", path)
+
+		case strings.HasPrefix(address, "vsock://"):
+			return errors.New("vsock listen addresses cannot be mixed with unix sockets")
+
+		default:
+			listener, err = net.Listen("tcp", address)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		defer listener.Close()
+		listeners = append(listeners, listener)
+	}
+
+	return web.ServeMultiple(listeners, server, flags, logger)
+}
+
+// removeStaleSocket removes a socket left behind by an earlier run, which would make Listen fail. Only a socket nobody answers
+// on is removed: anything else at path, or a socket still in use by another process, is an error.
+func removeStaleSocket(path string) error {
+
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot check socket path %s: %w", path, err)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("cannot listen on %s: the path exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("cannot listen on %s: the socket is in use", path)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot remove stale socket %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func hasUnixAddress(addresses []string) bool {
+	for _, address := range addresses {
+		if isUnixAddress(address) {
+			return true
+		}
+	}
+	return false
+}
+
+func isUnixAddress(address string) bool {
+	return strings.HasPrefix(address, "unix:")
+}
+
+func unixSocketPath(address string) string {
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		return path
+	}
+	return strings.TrimPrefix(address, "unix:")
+}
@@ -0,0 +1,62 @@
+package exporter
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveStaleSocket(t *testing.T) {
+
+	dir := t.TempDir()
+
+	// A socket whose listener is gone, as a killed exporter leaves it.
+	stale := filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	live := filepath.Join(dir, "live.sock")
+	l, err = net.Listen("unix", live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+		removed bool
+	}{
+		{"missing", filepath.Join(dir, "missing.sock"), false, true},
+		{"stale socket", stale, false, true},
+		{"socket in use", live, true, false},
+		{"regular file", file, true, false},
+		{"directory", dir, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := removeStaleSocket(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("removeStaleSocket() error = %v, want error %t", err, tt.wantErr)
+			}
+
+			_, err = os.Lstat(tt.path)
+			if removed := os.IsNotExist(err); removed != tt.removed {
+				t.Fatalf("removed = %t, want %t", removed, tt.removed)
+			}
+		})
+	}
+}
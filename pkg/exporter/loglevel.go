@@ -0,0 +1,145 @@
+package exporter
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+	"github.com/prezhdarov/prometheus-exporter/pkg/logging"
+	"github.com/prezhdarov/prometheus-exporter/pkg/secret"
+)
+
+var (
+	adminToken      = secret.Flag("web.admin-token", "Bearer token for administrative endpoints such as /-/log-level. Takes a value or a file:, env: or exec: reference. The endpoints are disabled while it is empty.")
+	overrideTimeout = flag.Duration("log.override.timeout", 15*time.Minute, "How long per-collector and per-target log level overrides last when the request does not say.")
+)
+
+// authorized checks the request carries the admin token. Without a configured token nobody is. The token is read holding
+// the configuration, but resolved after letting go - an exec: helper may take a while. Don't call it holding it yourself.
+func authorized(w http.ResponseWriter, r *http.Request, logger *slog.Logger) bool {
+
+	release := config.Hold()
+	adminToken := *adminToken
+	release()
+
+	if !adminToken.IsSet() {
+		http.Error(w, "administrative endpoints are disabled, set -web.admin-token to enable them", http.StatusForbidden)
+		return false
+	}
+
+	token, err := adminToken.Value()
+	if err != nil {
+		logger.Error("cannot resolve admin token", "err", err)
+		http.Error(w, "cannot resolve admin token", http.StatusInternalServerError)
+		return false
+	}
+
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+type levelOverride struct {
+	Collector string    `json:"collector,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Level     string    `json:"level"`
+	Expires   time.Time `json:"expires,omitzero"`
+}
+
+type levelState struct {
+	Level     string          `json:"level"`
+	Overrides []levelOverride `json:"overrides"`
+}
+
+// LogLevelHandler reads and changes log levels at runtime, meant for /-/log-level. Every request needs the -web.admin-token.
+//
+//	GET                                  current level and overrides
+//	POST level=debug[&duration=5m]       global level, reverting after duration if given
+//	POST level=debug&collector=x&target=y[&duration=5m]
+//	                                     override for a collector, a target or both, reverting after duration or -log.override.timeout
+//	DELETE collector=x&target=y          drop an override
+func LogLevelHandler(logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !authorized(w, r, logger) {
+			return
+		}
+
+		collector, target := r.FormValue("collector"), r.FormValue("target")
+
+		switch r.Method {
+		case http.MethodGet:
+
+		case http.MethodPost, http.MethodPut:
+
+			level, err := logging.ParseLevel(r.FormValue("level"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var duration time.Duration
+			if d := r.FormValue("duration"); d != "" {
+				if duration, err = time.ParseDuration(d); err != nil || duration < 0 {
+					http.Error(w, fmt.Sprintf("invalid duration %q", d), http.StatusBadRequest)
+					return
+				}
+			}
+
+			if collector == "" && target == "" {
+				logging.SetLevel(level, duration)
+				logger.Info("log level changed", "log_level", logging.LevelName(level), "duration", duration)
+				break
+			}
+
+			if duration == 0 {
+				release := config.Hold()
+				duration = *overrideTimeout
+				release()
+			}
+
+			o := logging.Override{Collector: collector, Target: target, Level: level}
+			if duration > 0 {
+				o.Expires = time.Now().Add(duration)
+			}
+
+			logging.SetOverride(o)
+			logger.Info("log level override set", "collector", collector, "target", target, "log_level", logging.LevelName(level), "duration", duration)
+
+		case http.MethodDelete:
+
+			if collector == "" && target == "" {
+				http.Error(w, "collector or target required", http.StatusBadRequest)
+				return
+			}
+
+			logging.RemoveOverride(collector, target)
+			logger.Info("log level override removed", "collector", collector, "target", target)
+
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+			http.Error(w, "only GET, POST, PUT or DELETE requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		state := levelState{Level: logging.LevelName(logging.Level()), Overrides: []levelOverride{}}
+
+		for _, o := range logging.Overrides() {
+			state.Overrides = append(state.Overrides, levelOverride{Collector: o.Collector, Target: o.Target, Level: logging.LevelName(o.Level), Expires: o.Expires})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	})
+}
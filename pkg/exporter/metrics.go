@@ -0,0 +1,90 @@
+package exporter
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// exporterRegistry holds the metrics the exporter keeps about itself: /probe handling and configuration reloads. It is always served on /metrics, whatever -disable.exporter.metrics says about the process and Go metrics.
+var exporterRegistry = prometheus.NewRegistry()
+
+type exporterMetrics struct {
+	coalesced   prometheus.Counter
+	cacheHits   prometheus.Counter
+	cacheMisses prometheus.Counter
+	rejected    *prometheus.CounterVec
+
+	reloadSuccess   prometheus.Gauge
+	reloadTimestamp prometheus.Gauge
+}
+
+var (
+	exporterMetricsMtx = sync.Mutex{}
+	exporterMetricsSet = make(map[string]*exporterMetrics)
+)
+
+// getExporterMetrics returns the exporter metrics for namespace, creating and registering them on first use.
+func getExporterMetrics(namespace string) *exporterMetrics {
+
+	exporterMetricsMtx.Lock()
+	defer exporterMetricsMtx.Unlock()
+
+	if em, ok := exporterMetricsSet[namespace]; ok {
+		return em
+	}
+
+	em := &exporterMetrics{
+		coalesced: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "probes_coalesced_total",
+			Help:      "Number of /probe requests served from a collection shared with another request.",
+		}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "probe_cache_hits_total",
+			Help:      "Number of /probe requests served from the result of an earlier collection.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "probe_cache_misses_total",
+			Help:      "Number of /probe requests with a minimum interval that had to collect fresh data.",
+		}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "probe_targets_rejected_total",
+			Help:      "Number of /probe requests refused because of -probe.targets.allow or -probe.targets.deny, by reason.",
+		}, []string{"reason"}),
+		reloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		}),
+		reloadTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload.",
+		}),
+	}
+
+	// Every reason shows up from the start, at 0.
+	for _, reason := range []string{targetDenied, targetNotAllowed, targetUnresolvable, targetInvalid} {
+		em.rejected.WithLabelValues(reason)
+	}
+
+	// The configuration loaded at start counts as the first successful reload.
+	em.reloadSuccess.Set(1)
+	em.reloadTimestamp.SetToCurrentTime()
+
+	exporterRegistry.MustRegister(em.coalesced, em.cacheHits, em.cacheMisses, em.rejected, em.reloadSuccess, em.reloadTimestamp)
+
+	exporterMetricsSet[namespace] = em
+
+	return em
+}
@@ -0,0 +1,148 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// probeKey identifies a probe by its registry, target, module, auth module and parameters. Requests with equal keys can share a
+// collection or a cached result.
+func probeKey(registry *collector.Registry, target, module, authModule string, params collector.Params) string {
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%p\x00", registry)
+	b.WriteString(target)
+	b.WriteString("\x00")
+	b.WriteString(module)
+	b.WriteString("\x00")
+	b.WriteString(authModule)
+	for _, name := range names {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strings.Join(params[name], ","))
+	}
+
+	return b.String()
+}
+
+// collectAll drains a collector set into a slice so the metrics can be handed to more than one request.
+func collectAll(cs *collector.CollectorSet) ([]prometheus.Metric, error) {
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- cs.CollectErr(ch)
+		close(ch)
+	}()
+
+	metrics := []prometheus.Metric{}
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	return metrics, <-done
+}
+
+// probeCollector wraps a CollectorSet for /probe. It serves results from the cache while they are younger than interval
+// and lets probes of the same key arriving within window share one Collect run. That run belongs to no single request: it
+// keeps the values of ctx, the trace, but not its cancellation, and is bounded by timeout instead, if there is one.
+type probeCollector struct {
+	key      string
+	interval time.Duration
+	window   time.Duration
+	ctx      context.Context
+	timeout  time.Duration
+	cs       *collector.CollectorSet
+	metrics  *exporterMetrics
+	cacheAge *prometheus.Desc
+}
+
+func newProbeCollector(ctx context.Context, namespace, key string, interval, window, timeout time.Duration, cs *collector.CollectorSet) *probeCollector {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return &probeCollector{
+		key:      key,
+		interval: interval,
+		window:   window,
+		ctx:      ctx,
+		timeout:  timeout,
+		cs:       cs,
+		metrics:  getExporterMetrics(namespace),
+		cacheAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "scrape", "cache_age_seconds"),
+			"Age of the served result. Zero if it was collected for this probe.",
+			nil, nil,
+		),
+	}
+}
+
+func (c *probeCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.cs.Describe(ch)
+	if c.interval > 0 {
+		ch <- c.cacheAge
+	}
+}
+
+func (c *probeCollector) Collect(ch chan<- prometheus.Metric) {
+
+	if c.interval > 0 {
+		if entry, ok := resultCache.get(c.key, c.interval); ok {
+			c.metrics.cacheHits.Inc()
+			c.send(ch, entry.metrics, time.Since(entry.collected))
+			return
+		}
+		c.metrics.cacheMisses.Inc()
+	}
+
+	// Whoever runs the collection shares it: leaving early must not fail it for everyone else, or for the cache.
+	metrics, shared, err := probeCoalescer.do(c.key, c.window, func() ([]prometheus.Metric, error) {
+		ctx := context.WithoutCancel(c.ctx)
+		if c.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+		}
+
+		c.cs.SetContext(ctx)
+
+		return collectAll(c.cs)
+	})
+
+	// A failed collection is served, but not kept: the next probe should try again rather than get the failure for the
+	// whole interval.
+	if shared {
+		c.metrics.coalesced.Inc()
+	} else if c.interval > 0 && err == nil {
+		resultCache.put(c.key, c.interval, metrics)
+	}
+
+	c.send(ch, metrics, 0)
+}
+
+func (c *probeCollector) send(ch chan<- prometheus.Metric, metrics []prometheus.Metric, age time.Duration) {
+
+	for _, m := range metrics {
+		ch <- m
+	}
+
+	if c.interval > 0 {
+		ch <- prometheus.MustNewConstMetric(c.cacheAge, prometheus.GaugeValue, age.Seconds())
+	}
+}
@@ -0,0 +1,94 @@
+package exporter
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+)
+
+// reloadMtx makes sure a SIGHUP and a /-/reload request don't reload at the same time.
+var reloadMtx = sync.Mutex{}
+
+// reload re-reads the configuration and records the outcome in the exporter metrics. On failure the old configuration stays.
+func reload(namespace string, logger *slog.Logger) error {
+
+	reloadMtx.Lock()
+	defer reloadMtx.Unlock()
+
+	em := getExporterMetrics(namespace)
+
+	if err := config.Reload(); err != nil {
+		em.reloadSuccess.Set(0)
+		logger.Error("configuration reload failed, keeping the previous configuration", "err", err)
+		return err
+	}
+
+	// Cached results may come from modules or credentials that just changed.
+	resultCache.flush()
+
+	em.reloadSuccess.Set(1)
+	em.reloadTimestamp.SetToCurrentTime()
+	logger.Info("configuration reloaded")
+
+	return nil
+}
+
+// ReloadHandler reloads the configuration on POST, meant for /-/reload. Like every administrative endpoint it needs the
+// -web.admin-token.
+func ReloadHandler(namespace string, logger *slog.Logger) http.Handler {
+
+	getExporterMetrics(namespace)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authorized(w, r, logger) {
+			return
+		}
+
+		if err := reload(namespace, logger); err != nil {
+			http.Error(w, "failed to reload config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("configuration reloaded\n"))
+	})
+}
+
+// WatchReload reloads the configuration every time the process gets a SIGHUP.
+func WatchReload(namespace string, logger *slog.Logger) {
+	watchReload(context.Background(), namespace, logger)
+}
+
+// watchReload is WatchReload until ctx is done.
+func watchReload(ctx context.Context, namespace string, logger *slog.Logger) {
+
+	getExporterMetrics(namespace)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				logger.Info("received SIGHUP, reloading configuration")
+				reload(namespace, logger)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,229 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+	"github.com/prezhdarov/prometheus-exporter/pkg/tracing"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// Route is an extra HTTP route for Run. Routes with a Text get a link on the landing page.
+type Route struct {
+	Path        string
+	Handler     http.Handler
+	Text        string
+	Description string
+}
+
+// Options describe an exporter for Run. Only Namespace is required.
+type Options struct {
+	// Namespace every metric name starts with. The exporter is called <Namespace>_exporter.
+	Namespace string
+	// Description is shown by -help and on the landing page.
+	Description string
+	// ListenAddress is the default of -http.address, :9169 if empty.
+	ListenAddress string
+	// ProbeParams are the /probe parameters handed to collectors besides target, module and auth_module, taking anything.
+	ProbeParams []string
+	// Params are /probe parameters with a type and the checks their values have to pass, see collector.ParamSpec.
+	Params []collector.ParamSpec
+	// Routes are added next to the standard ones. A route for "/" replaces the landing page.
+	Routes []Route
+	// Middleware wraps every route, the first one outermost.
+	Middleware []func(http.Handler) http.Handler
+	// Registry holds the API and collectors to serve, collector.DefaultRegistry (what RegisterAPI and RegisterCollector fill)
+	// if nil.
+	Registry *collector.Registry
+	// Init runs after the configuration and logger are set up and before the server starts. Load the API and collectors
+	// that need a logger here.
+	Init func(logger *slog.Logger) error
+}
+
+// defaultListenAddress is the default of -http.address when Options leave it empty.
+const defaultListenAddress = ":9169"
+
+// The flags of Run. They are defined once, here, so Run can be called more than once in a process - by tests, say.
+var (
+	listenAddress          = flag.String("http.address", defaultListenAddress, "Address and port to listen for http connections")
+	maxRequests            = flag.Int("prom.maxRequests", 20, "Maximum number of parallel scrape requests. Use 0 to disable.")
+	disableExporterTarget  = flag.Bool("disable.exporter.target", false, "Disable default target for /metrics path.")
+	disableExporterMetrics = flag.Bool("disable.exporter.metrics", true, "Disable exporter metrics in /metrics path. Always enabled if /metrics target disabled")
+	logLevel               = flag.String("log.level", "debug", "Log Level minimums. Available options are: debug,info,warn and error")
+	logFormat              = flag.String("log.format", "logfmt", "Log output format. Available options are: logfmt and json")
+
+	shutdownTimeout = flag.Duration("web.shutdown-timeout", 30*time.Second, "How long to wait for running scrapes to finish on SIGINT or SIGTERM.")
+)
+
+// Run is a whole exporter: it defines the usual flags, parses the configuration, sets up logging and tracing, serves
+// /metrics, /probe, /config, /-/reload, /-/log-level, /-/healthy, /-/ready and the landing page plus opts.Routes, and shuts
+// down gracefully on SIGINT or SIGTERM. It returns when the server has stopped, with an error if it did not stop cleanly.
+func Run(opts Options) error {
+
+	if opts.Namespace == "" {
+		return errors.New("exporter namespace is required")
+	}
+
+	if opts.Registry == nil {
+		opts.Registry = collector.DefaultRegistry
+	}
+
+	if opts.ListenAddress == "" {
+		opts.ListenAddress = defaultListenAddress
+	}
+
+	// The exporter's own address is the default, for -help too.
+	address := flag.Lookup("http.address")
+	address.DefValue = opts.ListenAddress
+	address.Value.Set(opts.ListenAddress)
+
+	name := opts.Namespace + "_exporter"
+
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.Usage = func() {
+		config.Usage(fmt.Sprintf("\n%s - %s\n", name, opts.Description))
+	}
+
+	// Modules may only list collectors of the registry served.
+	config.SetRegistry(opts.Registry)
+	config.Parse()
+
+	logger, err := config.NewLogger(logFormat, logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up the logger: %s\n", err)
+		return err
+	}
+
+	if err := tracing.Setup(name, logger); err != nil {
+		logger.Error("cannot set up tracing", "err", err)
+		return err
+	}
+
+	if opts.Init != nil {
+		if err := opts.Init(logger); err != nil {
+			logger.Error("exporter initialisation failed", "err", err)
+			return err
+		}
+	}
+
+	if err := opts.Registry.Validate(); err != nil {
+		logger.Error("invalid collector configuration", "err", err)
+		return err
+	}
+
+	// A collector that cannot be created shows up on /-/ready, the others are served anyway.
+	if err := opts.Registry.InitCollectors(logger); err != nil {
+		logger.Error("failed to create collectors", "err", err)
+	}
+
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+
+	WatchLogin(watchCtx, opts.Registry, logger)
+
+	mux := http.NewServeMux()
+
+	mux.Handle("/metrics", CreateHandlerWithRegistry(opts.Registry, !*disableExporterMetrics, *disableExporterTarget, *maxRequests, opts.Namespace, logger))
+	params := append(LegacyParams(opts.ProbeParams...), opts.Params...)
+	probe, err := ProbeHandler(opts.Registry, opts.Namespace, params, logger)
+	if err != nil {
+		logger.Error("invalid probe parameters", "err", err)
+		return err
+	}
+	mux.Handle("/probe", probe)
+	mux.Handle("/-/reload", ReloadHandler(opts.Namespace, logger))
+	mux.Handle("/config", ConfigHandler())
+	mux.Handle("/-/log-level", LogLevelHandler(logger))
+	mux.Handle("/-/healthy", HealthyHandler())
+	mux.Handle("/-/ready", ReadyHandlerWithRegistry(opts.Registry, logger))
+
+	links := []web.LandingLinks{
+		{Address: "/metrics", Text: "Metrics", Description: "metrics of the default target"},
+		{Address: "/probe", Text: "Probe", Description: "metrics of any target, see the form below"},
+		{Address: "/config", Text: "Configuration", Description: "effective configuration and where each value came from"},
+		{Address: "/-/healthy", Text: "Healthy"},
+		{Address: "/-/ready", Text: "Ready"},
+	}
+
+	landing := true
+
+	for _, route := range opts.Routes {
+		mux.Handle(route.Path, route.Handler)
+		if route.Path == "/" {
+			landing = false
+		}
+		if route.Text != "" {
+			links = append(links, web.LandingLinks{Address: route.Path, Text: route.Text, Description: route.Description})
+		}
+	}
+
+	if landing {
+		mux.Handle("/", LandingPageHandler(LandingPage{
+			Name:        name,
+			Description: opts.Description,
+			Links:       links,
+			Params:      params,
+			Registry:    opts.Registry,
+		}, logger))
+	}
+
+	var handler http.Handler = mux
+	for i := len(opts.Middleware) - 1; i >= 0; i-- {
+		handler = opts.Middleware[i](handler)
+	}
+
+	watchReload(watchCtx, opts.Namespace, logger)
+
+	webConfig := config.WebConfig(listenAddress)
+	logger.Info("listening on", "address", *webConfig.WebListenAddresses)
+
+	server := &http.Server{Handler: handler}
+
+	// On SIGINT or SIGTERM stop taking new connections and give running scrapes a chance to finish.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	stopped := make(chan error, 1)
+	go func() {
+		s := <-sig
+		signal.Stop(sig)
+
+		logger.Info("shutting down", "signal", s.String())
+
+		stopWatching()
+
+		release := config.Hold()
+		timeout := *shutdownTimeout
+		release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		stopped <- errors.Join(server.Shutdown(ctx), tracing.Shutdown(ctx))
+	}()
+
+	if err := ListenAndServe(server, webConfig, logger); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("server error", "err", err)
+		return err
+	}
+
+	if err := <-stopped; err != nil {
+		logger.Error("unclean shutdown", "err", err)
+		return err
+	}
+
+	logger.Info("exporter stopped")
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package exporter
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/collector"
+)
+
+// freeAddress returns a local address nothing listens on right now.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return ln.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+
+	defer func(args []string) { os.Args = args }(os.Args)
+
+	registry := collector.NewRegistry()
+	registry.RegisterAPI(&loginAPI{})
+
+	// Twice: the flags are defined once, and a second Run in the same process must not panic redefining them.
+	for run := 0; run < 2; run++ {
+
+		address := freeAddress(t)
+		os.Args = []string{"exporter", "-http.address", address, "-log.level", "error"}
+
+		var initialised atomic.Bool
+		done := make(chan error, 1)
+		go func() {
+			done <- Run(Options{
+				Namespace: "runtest",
+				Registry:  registry,
+				Init: func(*slog.Logger) error {
+					initialised.Store(true)
+					return nil
+				},
+			})
+		}()
+
+		up := false
+		for deadline := time.Now().Add(5 * time.Second); !up && time.Now().Before(deadline); {
+			resp, err := http.Get("http://" + address + "/-/healthy")
+			if err == nil {
+				resp.Body.Close()
+				up = resp.StatusCode == http.StatusOK
+			}
+			if !up {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		if !up {
+			t.Fatalf("run %d: the exporter did not come up on %s", run, address)
+		}
+		if !initialised.Load() {
+			t.Fatalf("run %d: Init was not called before serving", run)
+		}
+
+		for _, path := range []string{"/metrics", "/config", "/"} {
+			resp, err := http.Get("http://" + address + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("run %d: %s answered %d", run, path, resp.StatusCode)
+			}
+		}
+
+		if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("run %d: Run() = %v after SIGTERM", run, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("run %d: Run did not return after SIGTERM", run)
+		}
+	}
+}
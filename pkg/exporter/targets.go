@@ -0,0 +1,474 @@
+package exporter
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prezhdarov/prometheus-exporter/pkg/config"
+)
+
+var (
+	allowTargets   = config.Strings("probe.targets.allow", nil, "Targets /probe may collect from. Entries are host names, globs (*.example.com), regular expressions matching the whole host (re:db[0-9]+\\.example\\.com), CIDR ranges (10.0.0.0/8, checked against the resolved addresses) or \"private\" for loopback, private and link-local addresses. Can be given more than once. Empty allows every target not denied.")
+	denyTargets    = config.Strings("probe.targets.deny", nil, "Targets /probe refuses, in the same forms as -probe.targets.allow. Wins over the allow list.")
+	resolveTimeout = flag.Duration("probe.targets.resolve.timeout", 5*time.Second, "How long resolving a /probe target for CIDR rules may take. A target that cannot be resolved is refused.")
+)
+
+// The target rules are checked with every load of the configuration, so a broken rule fails the start or the reload rather
+// than every probe.
+func init() {
+	config.RegisterCheck(checkTargetRules)
+}
+
+// Reasons a target is refused, the reason label of the rejection counter.
+const (
+	targetDenied       = "denied"
+	targetNotAllowed   = "not_allowed"
+	targetUnresolvable = "unresolvable"
+	targetInvalid      = "invalid"
+)
+
+// targetRules is one parsed list of -probe.targets.allow or -probe.targets.deny.
+type targetRules struct {
+	hosts    map[string]bool
+	globs    []string
+	patterns []*regexp.Regexp
+	prefixes []netip.Prefix
+	private  bool
+}
+
+func parseTargetRules(flagName string, entries []string) (*targetRules, error) {
+
+	rules := &targetRules{hosts: make(map[string]bool)}
+
+	for _, entry := range entries {
+
+		switch {
+		case entry == "private":
+			rules.private = true
+
+		case strings.HasPrefix(entry, "re:"):
+			re, err := regexp.Compile("^(?:" + strings.TrimPrefix(entry, "re:") + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid -%s entry %q: %w", flagName, entry, err)
+			}
+			rules.patterns = append(rules.patterns, re)
+
+		case strings.Contains(entry, "/"):
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -%s entry %q: %w", flagName, entry, err)
+			}
+			rules.prefixes = append(rules.prefixes, prefix.Masked())
+
+		case strings.ContainsAny(entry, "*?["):
+			if _, err := path.Match(entry, ""); err != nil {
+				return nil, fmt.Errorf("invalid -%s entry %q: %w", flagName, entry, err)
+			}
+			rules.globs = append(rules.globs, strings.ToLower(entry))
+
+		default:
+			rules.hosts[strings.ToLower(entry)] = true
+		}
+	}
+
+	return rules, nil
+}
+
+func (tr *targetRules) empty() bool {
+	return len(tr.hosts) == 0 && len(tr.globs) == 0 && len(tr.patterns) == 0 && !tr.needAddrs()
+}
+
+// needAddrs tells whether the rules are about addresses, so the target has to be resolved.
+func (tr *targetRules) needAddrs() bool {
+	return len(tr.prefixes) > 0 || tr.private
+}
+
+// matchHost checks the name rules: exact hosts, globs and regular expressions.
+func (tr *targetRules) matchHost(host string) bool {
+
+	host = strings.ToLower(host)
+
+	if tr.hosts[host] {
+		return true
+	}
+
+	for _, glob := range tr.globs {
+		if ok, _ := path.Match(glob, host); ok {
+			return true
+		}
+	}
+
+	for _, re := range tr.patterns {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchAddr checks the address rules: CIDR ranges and "private".
+func (tr *targetRules) matchAddr(addr netip.Addr) bool {
+
+	addr = addr.Unmap()
+
+	if tr.private && (addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsUnspecified()) {
+		return true
+	}
+
+	for _, prefix := range tr.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// targetPolicy is the allow and deny lists together, with the -probe.targets.resolve.timeout they were read with.
+type targetPolicy struct {
+	allow, deny    *targetRules
+	resolveTimeout time.Duration
+}
+
+// empty tells whether there are no rules at all, in which case every target goes, as it always did.
+func (tp *targetPolicy) empty() bool {
+	return tp.allow.empty() && tp.deny.empty()
+}
+
+var (
+	targetPolicyMtx   = sync.Mutex{}
+	targetPolicyKey   string
+	targetPolicyCache *targetPolicy
+)
+
+// currentTargetPolicy parses the target flags, reusing the last result until a reload changes them. It reads flags, so
+// call it holding the configuration; the policy it returns stays as it is and can be used after letting go.
+func currentTargetPolicy() (*targetPolicy, error) {
+
+	allowEntries, denyEntries := allowTargets.Values(), denyTargets.Values()
+	key := strings.Join(allowEntries, "\x00") + "\x01" + strings.Join(denyEntries, "\x00") + "\x01" + resolveTimeout.String()
+
+	targetPolicyMtx.Lock()
+	defer targetPolicyMtx.Unlock()
+
+	if targetPolicyCache != nil && key == targetPolicyKey {
+		return targetPolicyCache, nil
+	}
+
+	allow, err := parseTargetRules("probe.targets.allow", allowEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := parseTargetRules("probe.targets.deny", denyEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	targetPolicyKey, targetPolicyCache = key, &targetPolicy{allow: allow, deny: deny, resolveTimeout: *resolveTimeout}
+
+	return targetPolicyCache, nil
+}
+
+// targetHost takes the host out of a target, which may be a bare host, host:port or a URL. It reads the target the way an API
+// building scheme://<target>/... would, and refuses anything that could make the two disagree on the host: userinfo, a path, a
+// query or fragment, escapes, and hosts that are neither a host name nor an IP address.
+func targetHost(target string) (string, error) {
+
+	if strings.ContainsAny(target, "@#?%\\") {
+		return "", fmt.Errorf("target %q: userinfo, query, fragment and escapes are not allowed", target)
+	}
+
+	for _, r := range target {
+		if r <= ' ' || r == 0x7f {
+			return "", fmt.Errorf("target %q: control characters and spaces are not allowed", target)
+		}
+	}
+
+	raw := target
+	if !strings.Contains(target, "://") {
+		raw = "//" + target
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("target %q: %w", target, err)
+	}
+
+	switch {
+	case u.Opaque != "" || u.User != nil || u.RawQuery != "" || u.ForceQuery || u.Fragment != "":
+		return "", fmt.Errorf("target %q: userinfo, query and fragment are not allowed", target)
+	case u.Path != "":
+		return "", fmt.Errorf("target %q: a path is not allowed", target)
+	}
+
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("target %q: invalid port", target)
+		}
+	} else if strings.HasSuffix(u.Host, ":") {
+		return "", fmt.Errorf("target %q: invalid port", target)
+	}
+
+	host := u.Hostname()
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if addr.Zone() != "" {
+			return "", fmt.Errorf("target %q: IPv6 zones are not allowed", target)
+		}
+		return host, nil
+	}
+
+	if strings.Contains(u.Host, "[") || !validHostname(host) {
+		return "", fmt.Errorf("target %q: %q is not a valid host name or IP address", target, host)
+	}
+
+	return strings.ToLower(strings.TrimSuffix(host, ".")), nil
+}
+
+// validHostname checks host against the host name rules, allowing underscores as plenty of internal names have them. A name
+// ending in a numeric or hex label is refused too: resolvers following inet_aton read 127.1 or 0x7f000001 as addresses.
+func validHostname(host string) bool {
+
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+
+	labels := strings.Split(host, ".")
+
+	last := strings.ToLower(labels[len(labels)-1])
+	if strings.HasPrefix(last, "0x") || strings.Trim(last, "0123456789") == "" {
+		return false
+	}
+
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// check returns why target is refused, or "" if it may be probed. Deny rules are checked first. With address rules in play
+// the target is resolved and refused if any of its addresses is denied, or if the allow list has to decide by address and
+// not every address is allowed. This only turns bad probes away early - the API resolves the name again when it connects,
+// so APIs should dial through TargetDialer, which applies the same rules to the address actually dialed.
+func (tp *targetPolicy) check(ctx context.Context, target string) (string, error) {
+
+	if tp.empty() {
+		return "", nil
+	}
+
+	host, err := targetHost(target)
+	if err != nil {
+		return targetInvalid, err
+	}
+
+	reason, allowedByName := tp.checkName(host)
+	if reason != "" {
+		return reason, nil
+	}
+
+	// No need to resolve if nothing left to check is about addresses.
+	if !tp.deny.needAddrs() && (allowedByName || !tp.allow.needAddrs()) {
+		return "", nil
+	}
+
+	addrs, err := resolveTarget(ctx, host, tp.resolveTimeout)
+	if err != nil {
+		return targetUnresolvable, err
+	}
+
+	for _, addr := range addrs {
+		if reason := tp.checkAddr(addr, allowedByName); reason != "" {
+			return reason, nil
+		}
+	}
+
+	return "", nil
+}
+
+// checkName applies the name rules to host. Unless it refuses the host, it tells whether the allow list is already satisfied
+// by the name, so that addresses only need to pass the deny list.
+func (tp *targetPolicy) checkName(host string) (reason string, allowedByName bool) {
+
+	if tp.deny.matchHost(host) {
+		return targetDenied, false
+	}
+
+	allowedByName = tp.allow.empty() || tp.allow.matchHost(host)
+
+	if !allowedByName && !tp.allow.needAddrs() {
+		return targetNotAllowed, false
+	}
+
+	return "", allowedByName
+}
+
+// checkAddr applies the address rules to one address of a host checkName let through.
+func (tp *targetPolicy) checkAddr(addr netip.Addr, allowedByName bool) string {
+
+	if tp.deny.matchAddr(addr) {
+		return targetDenied
+	}
+
+	if !allowedByName && !tp.allow.matchAddr(addr) {
+		return targetNotAllowed
+	}
+
+	return ""
+}
+
+// lookupNetIP resolves target names, a variable so tests can do without DNS.
+var lookupNetIP = net.DefaultResolver.LookupNetIP
+
+// resolveTarget returns the addresses of host, which may be an IP address already. A timeout of 0 leaves it to ctx.
+func resolveTarget(ctx context.Context, host string, timeout time.Duration) ([]netip.Addr, error) {
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}, nil
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	addrs, err := lookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+
+	return addrs, nil
+}
+
+// TargetRefusedError is what a TargetDialer returns for an address the target rules refuse.
+type TargetRefusedError struct {
+	Address string
+	Reason  string
+}
+
+func (e *TargetRefusedError) Error() string {
+	return fmt.Sprintf("connection to %s refused by the target rules: %s", e.Address, e.Reason)
+}
+
+// TargetDialer returns a DialContext connecting through d, but only where -probe.targets.allow and -probe.targets.deny let
+// it. The name rules are applied to the host being dialed and the address rules to every address actually connected to,
+// after resolution, so a name resolving differently than it did when /probe checked it gets nowhere. Refusals count in the
+// rejection metric of namespace. APIs should use it for every connection they make to a target, e.g.
+//
+//	&http.Transport{DialContext: exporter.TargetDialer(namespace, &net.Dialer{Timeout: 10 * time.Second})}
+//
+// Behind a proxy it is the proxy that gets checked, not the target.
+func TargetDialer(namespace string, d *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+
+	if d == nil {
+		d = &net.Dialer{}
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+
+		release := config.Hold()
+		policy, err := currentTargetPolicy()
+		release()
+		if err != nil {
+			return nil, err
+		}
+
+		if policy.empty() {
+			return d.DialContext(ctx, network, address)
+		}
+
+		refuse := func(reason string) error {
+			getExporterMetrics(namespace).rejected.WithLabelValues(reason).Inc()
+			return &TargetRefusedError{Address: address, Reason: reason}
+		}
+
+		host, err := targetHost(address)
+		if err != nil {
+			return nil, refuse(targetInvalid)
+		}
+
+		reason, allowedByName := policy.checkName(host)
+		if reason != "" {
+			return nil, refuse(reason)
+		}
+
+		dialer := *d
+		dialer.Control = nil
+		dialer.ControlContext = func(ctx context.Context, network, address string, c syscall.RawConn) error {
+
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return refuse(targetInvalid)
+			}
+
+			if reason := policy.checkAddr(addrPort.Addr(), allowedByName); reason != "" {
+				return refuse(reason)
+			}
+
+			switch {
+			case d.ControlContext != nil:
+				return d.ControlContext(ctx, network, address, c)
+			case d.Control != nil:
+				return d.Control(network, address, c)
+			}
+
+			return nil
+		}
+
+		return dialer.DialContext(ctx, network, address)
+	}
+}
+
+// CheckTargetRules parses -probe.targets.allow and -probe.targets.deny as they are. config.Parse and config.Reload check them
+// already, a configuration with a broken rule is never applied.
+func CheckTargetRules() error {
+	release := config.Hold()
+	defer release()
+
+	_, err := currentTargetPolicy()
+	return err
+}
+
+// checkTargetRules parses the target rules a configuration is about to get, for config.RegisterCheck.
+func checkTargetRules(value func(name string) string) error {
+
+	for _, name := range []string{"probe.targets.allow", "probe.targets.deny"} {
+
+		entries := config.NewStringList()
+		if err := entries.Set(value(name)); err != nil {
+			return err
+		}
+
+		if _, err := parseTargetRules(name, entries.Values()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
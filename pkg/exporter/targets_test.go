@@ -0,0 +1,243 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func testPolicy(t *testing.T, allow, deny []string) *targetPolicy {
+	t.Helper()
+
+	allowRules, err := parseTargetRules("probe.targets.allow", allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denyRules, err := parseTargetRules("probe.targets.deny", deny)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &targetPolicy{allow: allowRules, deny: denyRules}
+}
+
+// fakeDNS replaces the resolver with a fixed table for the test.
+func fakeDNS(t *testing.T, table map[string][]string) {
+	t.Helper()
+
+	orig := lookupNetIP
+	t.Cleanup(func() { lookupNetIP = orig })
+
+	lookupNetIP = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		addrs := []netip.Addr{}
+		for _, a := range table[host] {
+			addrs = append(addrs, netip.MustParseAddr(a))
+		}
+		if len(addrs) == 0 {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return addrs, nil
+	}
+}
+
+func TestTargetHost(t *testing.T) {
+
+	tests := []struct {
+		target string
+		host   string
+		ok     bool
+	}{
+		{"api.example.com", "api.example.com", true},
+		{"API.Example.com.", "api.example.com", true},
+		{"api.example.com:8443", "api.example.com", true},
+		{"https://api.example.com:8443", "api.example.com", true},
+		{"10.1.2.3:443", "10.1.2.3", true},
+		{"[2001:db8::1]:443", "2001:db8::1", true},
+		{"host_with_underscore.local", "host_with_underscore.local", true},
+
+		// Strings an API building https://<target>/... would send somewhere else than the host checked.
+		{"169.254.169.254#.example.com", "", false},
+		{"169.254.169.254?.example.com", "", false},
+		{"169.254.169.254/.example.com", "", false},
+		{"user@169.254.169.254", "", false},
+		{"a.example.com@169.254.169.254", "", false},
+		{"https://a.example.com@169.254.169.254/", "", false},
+		{"https://a.example.com/path", "", false},
+		{"169.254.169.254%23.example.com", "", false},
+		{"169.254.169.254\\.example.com", "", false},
+		{"a.example.com :80", "", false},
+		{"a.example.com\n", "", false},
+		{"[fe80::1%25eth0]:80", "", false},
+		{"a.example.com:99999", "", false},
+		{"a.example.com:", "", false},
+		{"a.example.com:80:90", "", false},
+		{"127.1", "", false},
+		{"0x7f000001", "", false},
+		{"2130706433", "", false},
+		{"-bad.example.com", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		host, err := targetHost(tt.target)
+		if (err == nil) != tt.ok {
+			t.Errorf("targetHost(%q): err = %v, want ok = %v", tt.target, err, tt.ok)
+			continue
+		}
+		if host != tt.host {
+			t.Errorf("targetHost(%q) = %q, want %q", tt.target, host, tt.host)
+		}
+	}
+}
+
+func TestTargetPolicyCheck(t *testing.T) {
+
+	fakeDNS(t, map[string][]string{
+		"a.example.com":      {"192.0.2.10"},
+		"internal.other.net": {"10.0.0.5"},
+		"public.other.net":   {"198.51.100.7"},
+		"mixed.other.net":    {"198.51.100.8", "127.0.0.1"},
+		"rebind.example.com": {"169.254.169.254"},
+	})
+
+	tests := []struct {
+		name   string
+		allow  []string
+		deny   []string
+		target string
+		want   string
+	}{
+		{"no rules lets anything through", nil, nil, "anything/at all", ""},
+		{"glob", []string{"*.example.com"}, nil, "a.example.com", ""},
+		{"glob with port", []string{"*.example.com"}, nil, "a.example.com:443", ""},
+		{"glob miss", []string{"*.example.com"}, nil, "evil.net", targetNotAllowed},
+		{"fragment bypass", []string{"*.example.com"}, nil, "169.254.169.254#.example.com", targetInvalid},
+		{"query bypass", []string{"*.example.com"}, nil, "169.254.169.254?x=.example.com", targetInvalid},
+		{"path bypass", []string{"*.example.com"}, nil, "169.254.169.254/.example.com", targetInvalid},
+		{"userinfo bypass", []string{"*.example.com"}, nil, "a.example.com@169.254.169.254", targetInvalid},
+		{"exact host", []string{"api.example.com"}, nil, "API.example.com", ""},
+		{"regex is anchored", []string{`re:db[0-9]+\.example\.com`}, nil, "db12.example.com.evil.net", targetNotAllowed},
+		{"regex", []string{`re:db[0-9]+\.example\.com`}, nil, "db12.example.com", ""},
+		{"deny wins", []string{"*.example.com"}, []string{"bad.example.com"}, "bad.example.com", targetDenied},
+		{"deny private literal", nil, []string{"private"}, "127.0.0.1", targetDenied},
+		{"deny private mapped", nil, []string{"private"}, "[::ffff:127.0.0.1]:80", targetDenied},
+		{"deny private resolved", nil, []string{"private"}, "internal.other.net", targetDenied},
+		{"deny private public", nil, []string{"private"}, "public.other.net", ""},
+		{"deny if any address denied", nil, []string{"private"}, "mixed.other.net", targetDenied},
+		{"deny link-local after name allow", []string{"*.example.com"}, []string{"private"}, "rebind.example.com", targetDenied},
+		{"unresolvable", nil, []string{"private"}, "nowhere.other.net", targetUnresolvable},
+		{"cidr allow", []string{"198.51.100.0/24"}, nil, "public.other.net", ""},
+		{"cidr allow needs every address", []string{"198.51.100.0/24"}, nil, "mixed.other.net", targetNotAllowed},
+		{"cidr allow miss", []string{"198.51.100.0/24"}, nil, "internal.other.net", targetNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, _ := testPolicy(t, tt.allow, tt.deny).check(context.Background(), tt.target)
+			if reason != tt.want {
+				t.Errorf("check(%q) = %q, want %q", tt.target, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTargetRulesErrors(t *testing.T) {
+
+	for _, entry := range []string{"re:(", "10.0.0.0/33", "[a-"} {
+		if _, err := parseTargetRules("probe.targets.allow", []string{entry}); err == nil {
+			t.Errorf("parseTargetRules(%q) did not fail", entry)
+		}
+	}
+}
+
+func TestTargetDialer(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	setRules := func(allow, deny []string) {
+		allowTargets.Reset()
+		denyTargets.Reset()
+		for _, v := range allow {
+			allowTargets.Set(v)
+		}
+		for _, v := range deny {
+			denyTargets.Set(v)
+		}
+	}
+	t.Cleanup(func() { setRules(nil, nil) })
+
+	dial := TargetDialer("test", &net.Dialer{})
+
+	tests := []struct {
+		name   string
+		allow  []string
+		deny   []string
+		reason string
+	}{
+		{"no rules", nil, nil, ""},
+		{"allowed by cidr", []string{"127.0.0.0/8"}, nil, ""},
+		{"denied address", nil, []string{"private"}, targetDenied},
+		{"address not allowed", []string{"192.0.2.0/24"}, nil, targetNotAllowed},
+		{"name not allowed", []string{"*.example.com"}, nil, targetNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRules(tt.allow, tt.deny)
+
+			conn, err := dial(context.Background(), "tcp", ln.Addr().String())
+			if conn != nil {
+				conn.Close()
+			}
+
+			var refused *TargetRefusedError
+			switch {
+			case tt.reason == "" && err != nil:
+				t.Fatalf("dial failed: %v", err)
+			case tt.reason != "" && !errors.As(err, &refused):
+				t.Fatalf("dial error = %v, want a refusal", err)
+			case tt.reason != "" && refused.Reason != tt.reason:
+				t.Fatalf("refused for %q, want %q", refused.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCheckTargetRules(t *testing.T) {
+
+	tests := []struct {
+		allow, deny string
+		wantErr     bool
+	}{
+		{"", "", false},
+		{"*.example.com,re:db[0-9]+", "private,10.0.0.0/8", false},
+		{"re:db[0-9", "", true},
+		{"", "10.0.0.0/33", true},
+		{"[", "", true},
+	}
+
+	for _, tt := range tests {
+		err := checkTargetRules(func(name string) string {
+			return map[string]string{"probe.targets.allow": tt.allow, "probe.targets.deny": tt.deny}[name]
+		})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("allow %q, deny %q: err = %v, want error %v", tt.allow, tt.deny, err, tt.wantErr)
+		}
+	}
+}
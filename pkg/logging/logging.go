@@ -0,0 +1,233 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Override changes the log level for one collector, one target or one collector on one target until Expires.
+// A zero Expires never expires.
+type Override struct {
+	Collector string
+	Target    string
+	Level     slog.Level
+	Expires   time.Time
+}
+
+func (o Override) active(now time.Time) bool {
+	return o.Expires.IsZero() || now.Before(o.Expires)
+}
+
+func (o Override) matches(collector, target string) bool {
+	return (o.Collector == "" || o.Collector == collector) && (o.Target == "" || o.Target == target)
+}
+
+var (
+	mtx    = sync.RWMutex{}
+	global = &slog.LevelVar{}
+	// base is the level set for good, which a timed level goes back to. revertGen tells a revert timer that fired while
+	// SetLevel was busy replacing it to leave the level alone.
+	base        slog.Level
+	revertTimer *time.Timer
+	revertGen   uint64
+	overrides   = make(map[string]Override)
+)
+
+// ParseLevel turns debug, info, warn or error into a slog.Level.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", s)
+	}
+	return l, nil
+}
+
+// LevelName is the lower case name of a level, as used by -log.level.
+func LevelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
+
+// Level returns the global log level.
+func Level() slog.Level {
+	return global.Level()
+}
+
+// SetLevel changes the global log level. With a positive d the change is temporary: after d it goes back to the last level
+// set without one, however many temporary levels came in between.
+func SetLevel(level slog.Level, d time.Duration) {
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	if revertTimer != nil {
+		revertTimer.Stop()
+		revertTimer = nil
+	}
+	revertGen++
+
+	global.Set(level)
+
+	if d <= 0 {
+		base = level
+		return
+	}
+
+	gen := revertGen
+	revertTimer = time.AfterFunc(d, func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if gen != revertGen {
+			return
+		}
+		global.Set(base)
+		revertTimer = nil
+	})
+}
+
+func overrideKey(collector, target string) string {
+	return collector + "\x00" + target
+}
+
+// SetOverride adds or replaces the override for its collector and target.
+func SetOverride(o Override) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	overrides[overrideKey(o.Collector, o.Target)] = o
+}
+
+// RemoveOverride drops the override for collector and target, if there is one.
+func RemoveOverride(collector, target string) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	delete(overrides, overrideKey(collector, target))
+}
+
+// Overrides lists the overrides still in effect, dropping expired ones on the way.
+func Overrides() []Override {
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	now := time.Now()
+	list := []Override{}
+
+	for key, o := range overrides {
+		if !o.active(now) {
+			delete(overrides, key)
+			continue
+		}
+		list = append(list, o)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return overrideKey(list[i].Collector, list[i].Target) < overrideKey(list[j].Collector, list[j].Target)
+	})
+
+	return list
+}
+
+// levelFor is the level in effect for a logger tagged with collector and target: the most verbose matching override, or
+// the global level if none matches.
+func levelFor(collector, target string) slog.Level {
+
+	mtx.RLock()
+	defer mtx.RUnlock()
+
+	level := global.Level()
+
+	if len(overrides) == 0 || (collector == "" && target == "") {
+		return level
+	}
+
+	now := time.Now()
+	matched := false
+
+	for _, o := range overrides {
+		if !o.active(now) || !o.matches(collector, target) {
+			continue
+		}
+		if !matched || o.Level < level {
+			level = o.Level
+			matched = true
+		}
+	}
+
+	return level
+}
+
+// Handler filters records by the global level and the overrides. The handler it wraps should let everything through.
+type Handler struct {
+	base      slog.Handler
+	collector string
+	target    string
+}
+
+// NewHandler wraps base, which should be enabled for every level, in a Handler.
+func NewHandler(base slog.Handler) *Handler {
+	return &Handler{base: base}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= levelFor(h.collector, h.target) && h.base.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.base.Handle(ctx, r)
+}
+
+// WithAttrs picks up a "collector" attribute, so loggers made with logger.With("collector", name) follow collector overrides.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+
+	c := *h
+	c.base = h.base.WithAttrs(attrs)
+
+	for _, a := range attrs {
+		if a.Key == "collector" && a.Value.Kind() == slog.KindString {
+			c.collector = a.Value.String()
+		}
+	}
+
+	return &c
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.base = h.base.WithGroup(name)
+	return &c
+}
+
+// ForTarget returns a logger following the overrides for target, without adding anything to its output. Loggers not built on
+// a Handler come back unchanged.
+func ForTarget(logger *slog.Logger, target string) *slog.Logger {
+
+	h, ok := logger.Handler().(*Handler)
+	if !ok {
+		return logger
+	}
+
+	c := *h
+	c.target = target
+
+	return slog.New(&c)
+}
+
+// ForCollector is ForTarget for a collector, for loggers that should follow its overrides without a collector attribute.
+func ForCollector(logger *slog.Logger, collector string) *slog.Logger {
+
+	h, ok := logger.Handler().(*Handler)
+	if !ok {
+		return logger
+	}
+
+	c := *h
+	c.collector = collector
+
+	return slog.New(&c)
+}
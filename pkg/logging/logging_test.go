@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func waitForLevel(t *testing.T, want slog.Level) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for Level() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("level = %s, want %s", Level(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSetLevelTimed(t *testing.T) {
+
+	defer SetLevel(slog.LevelInfo, 0)
+
+	SetLevel(slog.LevelWarn, 0)
+
+	// Overlapping temporary levels go back to the base level, not to the one in between.
+	SetLevel(slog.LevelDebug, time.Hour)
+	SetLevel(slog.LevelError, 20*time.Millisecond)
+
+	if Level() != slog.LevelError {
+		t.Fatalf("level = %s, want ERROR", Level())
+	}
+	waitForLevel(t, slog.LevelWarn)
+
+	// A level set for good ends the temporary one.
+	SetLevel(slog.LevelDebug, 20*time.Millisecond)
+	SetLevel(slog.LevelInfo, 0)
+	time.Sleep(50 * time.Millisecond)
+
+	if Level() != slog.LevelInfo {
+		t.Fatalf("level = %s after the timer would have fired, want INFO", Level())
+	}
+}
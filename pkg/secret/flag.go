@@ -0,0 +1,64 @@
+package secret
+
+import (
+	"flag"
+	"sync/atomic"
+	"time"
+)
+
+// Set makes *Secret a flag.Value. The flag takes a value or a file:, env: or exec: reference and prints as Redacted, so -help
+// and config dumps never show the value.
+func (s *Secret) Set(spec string) error {
+	*s = Parse(spec)
+	return nil
+}
+
+// FlagVar defines a Secret flag on fs.
+func FlagVar(fs *flag.FlagSet, s *Secret, name, usage string) {
+	fs.Var(s, name, usage)
+}
+
+// Flag defines a Secret flag on the command line and returns a pointer to it.
+func Flag(name, usage string) *Secret {
+	s := &Secret{}
+	FlagVar(flag.CommandLine, s, name, usage)
+	return s
+}
+
+// duration is a time.Duration flag that can be read while a reload sets it: secrets are resolved in the middle of scrapes,
+// which don't hold the configuration.
+type duration struct {
+	d atomic.Int64
+}
+
+// durationFlag defines a duration flag on the command line.
+func durationFlag(name string, value time.Duration, usage string) *duration {
+	d := &duration{}
+	d.d.Store(int64(value))
+	flag.Var(d, name, usage)
+	return d
+}
+
+func (d *duration) get() time.Duration {
+	return time.Duration(d.d.Load())
+}
+
+func (d *duration) String() string {
+	if d == nil {
+		return "0s"
+	}
+	return d.get().String()
+}
+
+func (d *duration) Set(value string) error {
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.d.Store(int64(v))
+	return nil
+}
+
+func (d *duration) Get() any {
+	return d.get()
+}
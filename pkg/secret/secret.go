@@ -0,0 +1,307 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Redacted is what a Secret shows instead of its value when printed, logged or marshalled.
+const Redacted = "<secret>"
+
+// Prefixes for secret references. Anything else is taken literally - use the literal: prefix for a value that happens to start
+// with one of the others.
+const (
+	FilePrefix    = "file:"
+	EnvPrefix     = "env:"
+	ExecPrefix    = "exec:"
+	LiteralPrefix = "literal:"
+)
+
+var (
+	refreshInterval = durationFlag("secret.refresh.interval", time.Minute, "How long a secret read from file:, env: or exec: references is cached before it is read again. Use 0 to only re-read on reload.")
+	execTimeout     = durationFlag("secret.exec.timeout", 10*time.Second, "Timeout for commands run to resolve exec: secret references.")
+
+	// generation is bumped by Invalidate. Resolved values from an older generation are read again on next use.
+	generation atomic.Uint64
+)
+
+// reference is the shared, lazily resolved part of a Secret. Copies of a Secret share it, so a value is resolved once for all of them.
+type reference struct {
+	spec string
+
+	mtx        sync.Mutex
+	value      string
+	resolved   time.Time
+	generation uint64
+	valid      bool
+	// pending is the resolve in progress, if any. It runs without mtx held, an exec: helper may take a while, and whoever
+	// needs the value meanwhile waits for it rather than starting another.
+	pending *resolveCall
+}
+
+// resolveCall is one resolve of a reference, shared by everyone asking while it runs.
+type resolveCall struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// Secret holds a credential or a reference to one (file:/path, env:NAME or exec:command args). References are resolved on first
+// use and re-read every -secret.refresh.interval or after Invalidate, so rotated secrets are picked up without a restart.
+// A Secret prints, logs and marshals as Redacted, so it never ends up in logs or debug pages by accident.
+type Secret struct {
+	ref *reference
+}
+
+// New wraps a plain value into a Secret. Unlike Parse it never treats the value as a reference.
+func New(value string) Secret {
+	if value == "" {
+		return Secret{}
+	}
+	return Secret{ref: &reference{spec: LiteralPrefix + value}}
+}
+
+// Parse creates a Secret from a value or a file:, env: or exec: reference. Nothing is read until Value is called.
+func Parse(spec string) Secret {
+	if spec == "" {
+		return Secret{}
+	}
+	return Secret{ref: &reference{spec: spec}}
+}
+
+// Invalidate makes every Secret read its reference again on next use. Call it on configuration reload.
+func Invalidate() {
+	generation.Add(1)
+}
+
+// Value returns the secret in clear text, resolving its reference if needed. Only hand it to whatever needs to authenticate.
+func (s Secret) Value() (string, error) {
+
+	if s.ref == nil {
+		return "", nil
+	}
+
+	r := s.ref
+
+	if value, ok := strings.CutPrefix(r.spec, LiteralPrefix); ok {
+		return value, nil
+	}
+	if !isReference(r.spec) {
+		return r.spec, nil
+	}
+
+	r.mtx.Lock()
+
+	gen := generation.Load()
+
+	if r.valid && r.generation == gen && (refreshInterval.get() == 0 || time.Since(r.resolved) < refreshInterval.get()) {
+		value := r.value
+		r.mtx.Unlock()
+		return value, nil
+	}
+
+	if call := r.pending; call != nil {
+		r.mtx.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+
+	call := &resolveCall{done: make(chan struct{})}
+	r.pending = call
+	r.mtx.Unlock()
+
+	call.value, call.err = resolve(r.spec)
+
+	r.mtx.Lock()
+	r.pending = nil
+	if call.err == nil {
+		r.value = call.value
+		r.resolved = time.Now()
+		r.generation = gen
+		r.valid = true
+	}
+	r.mtx.Unlock()
+
+	close(call.done)
+
+	return call.value, call.err
+}
+
+// IsSet tells whether the secret holds anything at all.
+func (s Secret) IsSet() bool {
+	return s.ref != nil && s.ref.spec != ""
+}
+
+// Reference returns the file:, env: or exec: reference the secret was given as, empty for a value given as it is. A reference
+// only says where the secret is, so it can be shown where the value can't.
+func (s Secret) Reference() string {
+	if !s.IsSet() || !isReference(s.ref.spec) {
+		return ""
+	}
+	return s.ref.spec
+}
+
+func (s Secret) String() string {
+	if !s.IsSet() {
+		return ""
+	}
+	return Redacted
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+func (s Secret) MarshalYAML() (any, error) {
+	return s.String(), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *Secret) UnmarshalYAML(unmarshal func(any) error) error {
+
+	var spec string
+	if err := unmarshal(&spec); err != nil {
+		return err
+	}
+
+	*s = Parse(spec)
+
+	return nil
+}
+
+func isReference(spec string) bool {
+	return strings.HasPrefix(spec, FilePrefix) || strings.HasPrefix(spec, EnvPrefix) || strings.HasPrefix(spec, ExecPrefix)
+}
+
+// resolve reads the value a reference points to. Trailing newlines are dropped, as files and command output usually end with one.
+func resolve(spec string) (string, error) {
+
+	switch {
+
+	case strings.HasPrefix(spec, FilePrefix):
+
+		path := strings.TrimPrefix(spec, FilePrefix)
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read secret file %s: %w", path, err)
+		}
+
+		return strings.TrimRight(string(content), "\r\n"), nil
+
+	case strings.HasPrefix(spec, EnvPrefix):
+
+		name := strings.TrimPrefix(spec, EnvPrefix)
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret environment variable %s is not set", name)
+		}
+
+		return value, nil
+
+	case strings.HasPrefix(spec, ExecPrefix):
+
+		args, err := splitArgs(strings.TrimPrefix(spec, ExecPrefix))
+		if err != nil {
+			return "", fmt.Errorf("invalid exec: secret reference: %w", err)
+		}
+		if len(args) == 0 {
+			return "", errors.New("empty exec: secret reference")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), execTimeout.get())
+		defer cancel()
+
+		// The command's stderr is left out on purpose, helpers tend to echo more than they should.
+		out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("secret helper %s failed: %w", args[0], err)
+		}
+
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+
+	return spec, nil
+}
+
+// splitArgs splits an exec: command line into arguments at unquoted white space, the way a shell would without expanding
+// anything: single quotes keep everything up to the next single quote, double quotes everything but a backslash escaping
+// " or \, and a backslash outside quotes keeps the next character as it is.
+func splitArgs(s string) ([]string, error) {
+
+	var args []string
+	var arg strings.Builder
+	inArg := false
+
+	for i := 0; i < len(s); i++ {
+
+		c := s[i]
+
+		switch {
+
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			arg.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+					i++
+				}
+				arg.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inArg = true
+
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			arg.WriteByte(s[i])
+			inArg = true
+
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
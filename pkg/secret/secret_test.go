@@ -0,0 +1,102 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSplitArgs(t *testing.T) {
+
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"  vault read  -field=password secret/db ", []string{"vault", "read", "-field=password", "secret/db"}, false},
+		{`helper 'two words' "and \"more\"" back\ slash`, []string{"helper", "two words", `and "more"`, "back slash"}, false},
+		{`helper '' x`, []string{"helper", "", "x"}, false},
+		{`pre'fix'"ed"`, []string{"prefixed"}, false},
+		{`a "b\c"`, []string{"a", `b\c`}, false},
+		{`a 'b`, nil, true},
+		{`a "b`, nil, true},
+		{`a \`, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := splitArgs(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitArgs(%q) error = %v, want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValueResolvesOnce(t *testing.T) {
+
+	dir := t.TempDir()
+	count := filepath.Join(dir, "count")
+
+	// The helper counts its runs and takes long enough for the callers to pile up.
+	script := filepath.Join(dir, "helper.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho run >> \"$1\"\nsleep 0.2\necho 's3cret'\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	s := Parse(ExecPrefix + script + " '" + count + "'")
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := s.Value(); err != nil || value != "s3cret" {
+				t.Errorf("Value() = %q, %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	content, err := os.ReadFile(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := len(content) / len("run\n"); runs != 1 {
+		t.Fatalf("helper ran %d times, want 1", runs)
+	}
+
+	// A cached value does not wait for anything.
+	begin := time.Now()
+	if _, err := s.Value(); err != nil || time.Since(begin) > 100*time.Millisecond {
+		t.Fatalf("cached Value() took %s, err %v", time.Since(begin), err)
+	}
+}
+
+func TestReference(t *testing.T) {
+
+	tests := []struct {
+		secret Secret
+		want   string
+	}{
+		{Parse("file:/run/secrets/pw"), "file:/run/secrets/pw"},
+		{Parse("env:PASSWORD"), "env:PASSWORD"},
+		{Parse("exec:get-secret api"), "exec:get-secret api"},
+		{Parse("hunter2"), ""},
+		{Parse("literal:file:x"), ""},
+		{New("file:x"), ""},
+		{Secret{}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.secret.Reference(); got != tt.want {
+			t.Errorf("Reference() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testSpans() []SpanData {
+
+	start := time.Unix(1700000000, 0)
+
+	return []SpanData{
+		{
+			Name:       "probe",
+			Kind:       KindServer,
+			TraceID:    TraceID{1},
+			SpanID:     SpanID{2},
+			Start:      start,
+			End:        start.Add(1500 * time.Millisecond),
+			Attributes: []Attribute{String("target", "a.example.com"), Int("get_calls", 3)},
+		},
+		{
+			Name:         "Login",
+			Kind:         KindClient,
+			TraceID:      TraceID{1},
+			SpanID:       SpanID{3},
+			ParentSpanID: SpanID{2},
+			Start:        start,
+			End:          start.Add(time.Second),
+			Error:        "login refused",
+		},
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+
+	var buf bytes.Buffer
+	if err := NewJSONExporter(&buf).ExportSpans(context.Background(), testSpans()); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := json.NewDecoder(&buf)
+
+	var probe, login jsonSpan
+	if err := decoder.Decode(&probe); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoder.Decode(&login); err != nil {
+		t.Fatal(err)
+	}
+
+	if probe.Name != "probe" || probe.TraceID != (TraceID{1}).String() || probe.ParentSpanID != "" || probe.Duration != 1.5 {
+		t.Errorf("probe span = %+v", probe)
+	}
+	if probe.Attributes["target"] != "a.example.com" || probe.Attributes["get_calls"] != float64(3) {
+		t.Errorf("probe attributes = %v", probe.Attributes)
+	}
+	if login.ParentSpanID != (SpanID{2}).String() || login.Error != "login refused" {
+		t.Errorf("login span = %+v", login)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+
+	var got otlpRequest
+	status := http.StatusOK
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("body is not OTLP JSON: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	e := NewOTLPExporter(server.URL+"/v1/traces", "test_exporter", time.Second)
+	defer e.Shutdown(context.Background())
+
+	if err := e.ExportSpans(context.Background(), testSpans()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("request = %+v", got)
+	}
+
+	service := got.ResourceSpans[0].Resource.Attributes
+	if len(service) != 1 || service[0].Key != "service.name" || service[0].Value["stringValue"] != "test_exporter" {
+		t.Errorf("resource attributes = %+v", service)
+	}
+
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	probe, login := spans[0], spans[1]
+
+	if probe.Kind != 2 || login.Kind != 3 {
+		t.Errorf("kinds = %d, %d, want server 2 and client 3", probe.Kind, login.Kind)
+	}
+	if probe.StartTimeUnixNano != "1700000000000000000" || probe.EndTimeUnixNano != "1700000001500000000" {
+		t.Errorf("probe times = %s - %s", probe.StartTimeUnixNano, probe.EndTimeUnixNano)
+	}
+	if len(probe.Attributes) != 2 || probe.Attributes[1].Value["intValue"] != "3" {
+		t.Errorf("probe attributes = %+v", probe.Attributes)
+	}
+	if probe.Status.Code != 0 || login.Status.Code != 2 || login.Status.Message != "login refused" {
+		t.Errorf("statuses = %+v, %+v", probe.Status, login.Status)
+	}
+	if login.ParentSpanID != (SpanID{2}).String() {
+		t.Errorf("login parent = %q", login.ParentSpanID)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := e.ExportSpans(context.Background(), testSpans()); err == nil {
+		t.Fatal("a failed export was not reported")
+	}
+
+	// Nothing listening.
+	server.Close()
+	if err := e.ExportSpans(context.Background(), testSpans()); err == nil {
+		t.Fatalf("export to a closed endpoint = %v", err)
+	}
+}
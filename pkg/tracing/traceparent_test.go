@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		value   string
+		sampled bool
+		ok      bool
+	}{
+		{"00-" + traceID + "-" + spanID + "-01", true, true},
+		{"00-" + traceID + "-" + spanID + "-00", false, true},
+		{" 00-" + traceID + "-" + spanID + "-03 ", true, true},
+		// A later version is read as far as 00 goes.
+		{"01-" + traceID + "-" + spanID + "-01-what-comes-next", true, true},
+
+		{"00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"0-" + traceID + "-" + spanID + "-01", false, false},
+		{"00-00000000000000000000000000000000-" + spanID + "-01", false, false},
+		{"00-" + traceID + "-0000000000000000-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", false, false},
+		{"00-" + traceID[:30] + "-" + spanID + "-01", false, false},
+		{"00-" + traceID + "-" + spanID + "-1", false, false},
+		{"00-" + traceID + "-" + spanID + "-zz", false, false},
+		{"00-" + traceID + "-" + spanID, false, false},
+		{"", false, false},
+	}
+
+	for _, tt := range tests {
+		sc, err := ParseTraceparent(tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseTraceparent(%q) error = %v, want ok %t", tt.value, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || sc.Sampled != tt.sampled || !sc.Remote {
+			t.Errorf("ParseTraceparent(%q) = %+v", tt.value, sc)
+		}
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	Inject(ContextWithSpanContext(context.Background(), sc), header)
+	if got := header.Get("traceparent"); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("Inject() set traceparent %q", got)
+	}
+
+	if got := SpanContextFromContext(Extract(context.Background(), header)); got != sc {
+		t.Fatalf("Extract() = %+v, want %+v", got, sc)
+	}
+
+	// A broken header leaves the context alone.
+	header.Set("traceparent", "garbage")
+	if got := SpanContextFromContext(Extract(context.Background(), header)); got.IsValid() {
+		t.Fatalf("Extract() of a broken header = %+v", got)
+	}
+}
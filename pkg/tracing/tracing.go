@@ -0,0 +1,328 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	exporterFlag = flag.String("tracing.exporter", "", "Where to send scrape traces: stdout, file:<path> (both JSON lines) or otlp. Empty disables tracing.")
+	otlpEndpoint = flag.String("tracing.otlp.endpoint", "http://localhost:4318/v1/traces", "OTLP/HTTP traces endpoint used by -tracing.exporter=otlp.")
+	otlpTimeout  = flag.Duration("tracing.otlp.timeout", 10*time.Second, "Timeout for sending a batch of spans to the OTLP endpoint.")
+	sampleRatio  = flag.Float64("tracing.sample.ratio", 1, "Share of traces to sample when the request does not come with a traceparent header. Incoming traceparent sampling decisions are always honoured.")
+)
+
+// Exporter is where finished spans go. ExportSpans is called from a single goroutine with batches of spans.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// Attribute is a key and value recorded on a span. Values are strings, bools, integers or floats; anything else is recorded
+// in its fmt form.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String, Int, Bool and Float64 make attributes.
+func String(key, value string) Attribute      { return Attribute{key, value} }
+func Int(key string, value int) Attribute     { return Attribute{key, int64(value)} }
+func Bool(key string, value bool) Attribute   { return Attribute{key, value} }
+func Float64(key string, v float64) Attribute { return Attribute{key, v} }
+
+// SpanKind tells a span serving a request from one doing work inside the process.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = iota
+	KindServer
+	KindClient
+)
+
+// SpanData is a finished span as handed to an Exporter.
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+	Error        string
+}
+
+// Span is a timed operation. A nil *Span is valid and does nothing, which is what Start returns while tracing is off.
+type Span struct {
+	mtx   sync.Mutex
+	sc    SpanContext
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the identifiers of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// IsRecording tells whether the span will be exported.
+func (s *Span) IsRecording() bool {
+	return s != nil && s.sc.Sampled
+}
+
+// SetAttributes records attributes on the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// SetError marks the span as failed with err. A nil err does nothing.
+func (s *Span) SetError(err error) {
+	if err == nil || !s.IsRecording() {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and queues it for export. Only the first call counts.
+func (s *Span) End() {
+
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mtx.Lock()
+	if s.ended {
+		s.mtx.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mtx.Unlock()
+
+	mtx.RLock()
+	defer mtx.RUnlock()
+
+	if active != nil {
+		active.queue(data)
+	}
+}
+
+type contextKey struct{}
+
+// ContextWithSpanContext returns ctx with sc as the parent for spans started from it.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context in ctx, or an invalid one.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if ctx == nil {
+		return SpanContext{}
+	}
+	sc, _ := ctx.Value(contextKey{}).(SpanContext)
+	return sc
+}
+
+// Start begins a span as a child of the one in ctx, or as the root of a new trace, and returns a context carrying it. With
+// tracing off it returns ctx and a nil span.
+func Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+
+	if !Enabled() {
+		return ctx, nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	parent := SpanContextFromContext(ctx)
+
+	s := &Span{data: SpanData{Name: name, Kind: kind, Start: time.Now(), Attributes: attrs}}
+
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.data.ParentSpanID = parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = sample()
+	}
+
+	rand.Read(s.sc.SpanID[:])
+
+	s.data.TraceID = s.sc.TraceID
+	s.data.SpanID = s.sc.SpanID
+
+	return ContextWithSpanContext(ctx, s.sc), s
+}
+
+func sample() bool {
+	ratio := *sampleRatio
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return float64(binary.BigEndian.Uint64(b[:])>>11)/(1<<53) < ratio
+}
+
+// processor batches finished spans for the exporter so ending a span never waits on the network.
+type processor struct {
+	exporter Exporter
+	spans    chan SpanData
+	done     chan struct{}
+	logger   *slog.Logger
+}
+
+const (
+	batchSize     = 512
+	batchInterval = 5 * time.Second
+)
+
+var (
+	mtx    = sync.RWMutex{}
+	active *processor
+)
+
+// Enabled tells whether an exporter is set.
+func Enabled() bool {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	return active != nil
+}
+
+// SetExporter sends finished spans to e from now on, shutting the previous exporter down. A nil e turns tracing off.
+func SetExporter(e Exporter, logger *slog.Logger) {
+
+	Shutdown(context.Background())
+
+	if e == nil {
+		return
+	}
+
+	p := &processor{exporter: e, spans: make(chan SpanData, 4*batchSize), done: make(chan struct{}), logger: logger}
+	go p.run()
+
+	mtx.Lock()
+	active = p
+	mtx.Unlock()
+}
+
+// Shutdown exports the spans still queued and shuts the exporter down. Tracing is off afterwards.
+func Shutdown(ctx context.Context) error {
+
+	mtx.Lock()
+	p := active
+	active = nil
+	mtx.Unlock()
+
+	if p == nil {
+		return nil
+	}
+
+	close(p.spans)
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return p.exporter.Shutdown(ctx)
+}
+
+// queue hands a span to the exporter goroutine. Spans are dropped rather than block a scrape when the exporter falls behind.
+func (p *processor) queue(data SpanData) {
+	select {
+	case p.spans <- data:
+	default:
+		p.logger.Debug("trace exporter queue full, dropping span", "name", data.Name)
+	}
+}
+
+func (p *processor) run() {
+
+	defer close(p.done)
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.exporter.ExportSpans(context.Background(), batch); err != nil {
+			p.logger.Error("failed to export spans", "count", len(batch), "err", err)
+		}
+		batch = make([]SpanData, 0, batchSize)
+	}
+
+	for {
+		select {
+		case data, ok := <-p.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Setup installs the exporter chosen with -tracing.exporter. serviceName names the exporter in OTLP resources.
+func Setup(serviceName string, logger *slog.Logger) error {
+
+	spec := *exporterFlag
+
+	switch {
+	case spec == "":
+		return nil
+	case spec == "stdout":
+		SetExporter(NewJSONExporter(os.Stdout), logger)
+	case strings.HasPrefix(spec, "file:"):
+		f, err := os.OpenFile(strings.TrimPrefix(spec, "file:"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("cannot open trace file: %w", err)
+		}
+		SetExporter(NewJSONExporter(f), logger)
+	case spec == "otlp":
+		SetExporter(NewOTLPExporter(*otlpEndpoint, serviceName, *otlpTimeout), logger)
+	default:
+		return errors.New("unknown -tracing.exporter " + spec + ", use stdout, file:<path> or otlp")
+	}
+
+	logger.Info("tracing enabled", "exporter", spec)
+
+	return nil
+}